@@ -19,6 +19,8 @@ func (ident *Ident) Accept(visitor Visitor) {
 
 
 type Initialize struct {
+	Type *Type
+	Spreads []Spread
 	Fields []InitializeField
 	Location *tokens.Location
 }
@@ -28,8 +30,18 @@ func (init *Initialize) Loc() *tokens.Location {
 }
 
 func (init *Initialize) Accept(visitor Visitor) {
+	if init.Type != nil {
+		init.Type.Accept(visitor)
+	}
+
 	visitor.VisitPreInitialize(init)
 
+	if len(init.Spreads) > 0 {
+		for _, spread := range init.Spreads {
+			spread.Accept(visitor)
+		}
+	}
+
 	if len(init.Fields) > 0 {
 		for _, field := range init.Fields {
 			field.Accept(visitor)
@@ -47,6 +59,7 @@ const (
 	Integer
 	Float
 	Boolean
+	Null
 )
 
 type Literal struct {
@@ -91,6 +104,7 @@ func (call *Call) Accept(visitor Visitor) {
 type Member struct {
 	Object Expr
 	Field Expr
+	Optional bool
 	Location *tokens.Location
 }
 
@@ -106,6 +120,179 @@ func (member *Member) Accept(visitor Visitor) {
 }
 
 
+type Operator int
+
+const (
+	Coalesce Operator = iota
+	Equal
+	NotEqual
+)
+
+type Binary struct {
+	Op Operator
+	Left Expr
+	Right Expr
+	Location *tokens.Location
+}
+
+func (binary *Binary) Loc() *tokens.Location {
+	return binary.Location
+}
+
+func (binary *Binary) Accept(visitor Visitor) {
+	binary.Left.Accept(visitor)
+	visitor.VisitInlineExpr(binary.Left)
+	binary.Right.Accept(visitor)
+	visitor.VisitInlineExpr(binary.Right)
+	visitor.VisitBinary(binary)
+}
+
+
+type IsNull struct {
+	Value Expr
+	Location *tokens.Location
+}
+
+func (isNull *IsNull) Loc() *tokens.Location {
+	return isNull.Location
+}
+
+func (isNull *IsNull) Accept(visitor Visitor) {
+	isNull.Value.Accept(visitor)
+	visitor.VisitInlineExpr(isNull.Value)
+	visitor.VisitIsNull(isNull)
+}
+
+
+type Not struct {
+	Value Expr
+	Location *tokens.Location
+}
+
+func (not *Not) Loc() *tokens.Location {
+	return not.Location
+}
+
+func (not *Not) Accept(visitor Visitor) {
+	not.Value.Accept(visitor)
+	visitor.VisitInlineExpr(not.Value)
+	visitor.VisitNot(not)
+}
+
+
+type Conditional struct {
+	Cond Expr
+	Then Expr
+	Else Expr
+	Location *tokens.Location
+}
+
+func (conditional *Conditional) Loc() *tokens.Location {
+	return conditional.Location
+}
+
+func (conditional *Conditional) Accept(visitor Visitor) {
+	conditional.Cond.Accept(visitor)
+	visitor.VisitInlineExpr(conditional.Cond)
+	visitor.VisitPreConditional(conditional)
+	conditional.Then.Accept(visitor)
+	visitor.VisitInlineExpr(conditional.Then)
+	visitor.VisitConditionalElse(conditional)
+	conditional.Else.Accept(visitor)
+	visitor.VisitInlineExpr(conditional.Else)
+	visitor.VisitConditional(conditional)
+}
+
+
+type Array struct {
+	Elements []Expr
+	Location *tokens.Location
+}
+
+func (array *Array) Loc() *tokens.Location {
+	return array.Location
+}
+
+func (array *Array) Accept(visitor Visitor) {
+	visitor.VisitPreArray(array)
+
+	if len(array.Elements) > 0 {
+		for _, elem := range array.Elements {
+			elem.Accept(visitor)
+			visitor.VisitInlineExpr(elem)
+			visitor.VisitArrayElement(elem)
+		}
+	}
+
+	visitor.VisitArray(array)
+}
+
+
+type Comprehension struct {
+	Result Expr
+	Key *Ident
+	Value *Ident
+	Collection Expr
+	Filter Expr
+	Location *tokens.Location
+}
+
+func (comprehension *Comprehension) Loc() *tokens.Location {
+	return comprehension.Location
+}
+
+func (comprehension *Comprehension) Accept(visitor Visitor) {
+	visitor.VisitPreComprehension(comprehension)
+	comprehension.Collection.Accept(visitor)
+	visitor.VisitInlineExpr(comprehension.Collection)
+	visitor.VisitComprehensionLoop(comprehension)
+
+	if comprehension.Filter != nil {
+		comprehension.Filter.Accept(visitor)
+		visitor.VisitInlineExpr(comprehension.Filter)
+		visitor.VisitComprehensionFilter(comprehension)
+	}
+
+	comprehension.Result.Accept(visitor)
+	visitor.VisitInlineExpr(comprehension.Result)
+	visitor.VisitComprehension(comprehension)
+}
+
+
+type MatchArm struct {
+	Pattern Pattern
+	Result Expr
+	Location *tokens.Location
+}
+
+type Match struct {
+	Value Expr
+	Arms []MatchArm
+	Location *tokens.Location
+}
+
+func (match *Match) Loc() *tokens.Location {
+	return match.Location
+}
+
+func (match *Match) Accept(visitor Visitor) {
+	match.Value.Accept(visitor)
+	visitor.VisitInlineExpr(match.Value)
+	visitor.VisitPreMatch(match)
+
+	for i := range match.Arms {
+		arm := &match.Arms[i]
+
+		visitor.VisitPreMatchArm(arm)
+		arm.Result.Accept(visitor)
+		visitor.VisitInlineExpr(arm.Result)
+		visitor.VisitMatchArm(arm)
+	}
+
+	visitor.VisitMatch(match)
+}
+
+
 /**
  * Expression definitions
  */
@@ -114,3 +301,10 @@ func (init *Initialize) exprNode() {}
 func (literal *Literal) exprNode() {}
 func (call *Call) exprNode() {}
 func (member *Member) exprNode() {}
+func (binary *Binary) exprNode() {}
+func (isNull *IsNull) exprNode() {}
+func (not *Not) exprNode() {}
+func (conditional *Conditional) exprNode() {}
+func (array *Array) exprNode() {}
+func (comprehension *Comprehension) exprNode() {}
+func (match *Match) exprNode() {}
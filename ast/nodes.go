@@ -4,9 +4,22 @@ import (
 	"dmeijboom/config/tokens"
 )
 
+type Annotation struct {
+	Name *Ident
+	Args []*Literal
+	Location *tokens.Location
+}
+
+func (annotation *Annotation) Loc() *tokens.Location {
+	return annotation.Location
+}
+
+
 type Field struct {
 	Name *Ident
 	Type *Type
+	Annotations []Annotation
+	Doc string
 }
 
 func (field *Field) Loc() *tokens.Location {
@@ -22,6 +35,7 @@ func (field *Field) Accept(visitor Visitor) {
 
 type Type struct {
 	Name *Ident
+	Base *Type
 	Array bool
 	Optional bool
 	Fields []Field
@@ -32,6 +46,10 @@ func (type_ *Type) Loc() *tokens.Location {
 }
 
 func (type_ *Type) Accept(visitor Visitor) {
+	if type_.Base != nil {
+		type_.Base.Accept(visitor)
+	}
+
 	if len(type_.Fields) > 0 {
 		for _, field := range type_.Fields {
 			field.Accept(visitor)
@@ -59,3 +77,19 @@ func (initField *InitializeField) Accept(visitor Visitor) {
 	visitor.VisitInlineExpr(initField.Value)
 	visitor.VisitInitializeField(initField)
 }
+
+
+type Spread struct {
+	Value Expr
+	Location *tokens.Location
+}
+
+func (spread *Spread) Loc() *tokens.Location {
+	return spread.Location
+}
+
+func (spread *Spread) Accept(visitor Visitor) {
+	spread.Value.Accept(visitor)
+	visitor.VisitInlineExpr(spread.Value)
+	visitor.VisitSpread(spread)
+}
@@ -0,0 +1,51 @@
+package ast
+
+import (
+	"dmeijboom/config/tokens"
+)
+
+type Pattern interface {
+	Loc() *tokens.Location
+	patternNode()
+}
+
+
+type WildcardPattern struct {
+	Location *tokens.Location
+}
+
+func (wildcard *WildcardPattern) Loc() *tokens.Location {
+	return wildcard.Location
+}
+
+
+type LiteralPattern struct {
+	Literal *Literal
+}
+
+func (literal *LiteralPattern) Loc() *tokens.Location {
+	return literal.Literal.Loc()
+}
+
+
+type FieldPattern struct {
+	Name *Ident
+	Pattern Pattern
+}
+
+type TypePattern struct {
+	Type *Type
+	Fields []FieldPattern
+}
+
+func (typePattern *TypePattern) Loc() *tokens.Location {
+	return typePattern.Type.Loc()
+}
+
+
+/**
+ * Pattern definitions
+ */
+func (wildcard *WildcardPattern) patternNode() {}
+func (literal *LiteralPattern) patternNode() {}
+func (typePattern *TypePattern) patternNode() {}
@@ -27,6 +27,7 @@ func (block *Block) Accept(visitor Visitor) {
 type Section struct {
 	Name *Ident
 	Block *Block
+	Type *Type
 }
 
 func (section *Section) Loc() *tokens.Location {
@@ -34,6 +35,10 @@ func (section *Section) Loc() *tokens.Location {
 }
 
 func (section *Section) Accept(visitor Visitor) {
+	if section.Type != nil {
+		section.Type.Accept(visitor)
+	}
+
 	section.Name.Accept(visitor)
 	visitor.VisitPreSection(section)
 	section.Block.Accept(visitor)
@@ -44,6 +49,8 @@ func (section *Section) Accept(visitor Visitor) {
 type Typedef struct {
 	Name *Ident
 	Type *Type
+	Annotations []Annotation
+	Doc string
 }
 
 func (typedef *Typedef) Loc() *tokens.Location {
@@ -58,9 +65,12 @@ func (typedef *Typedef) Accept(visitor Visitor) {
 
 
 type Assign struct {
+	Const bool
 	Name *Ident
 	Type *Type
 	Value Expr
+	Annotations []Annotation
+	Doc string
 }
 
 func (assign *Assign) Loc() *tokens.Location {
@@ -68,7 +78,9 @@ func (assign *Assign) Loc() *tokens.Location {
 }
 
 func (assign *Assign) Accept(visitor Visitor) {
-	assign.Type.Accept(visitor)
+	if assign.Type != nil {
+		assign.Type.Accept(visitor)
+	}
 
 	if assign.Value != nil {
 		assign.Value.Accept(visitor)
@@ -95,6 +107,116 @@ func (exprStmt *ExprStmt) Accept(visitor Visitor) {
 }
 
 
+type Assert struct {
+	Cond Expr
+	Message Expr
+	Location *tokens.Location
+}
+
+func (assert *Assert) Loc() *tokens.Location {
+	return assert.Location
+}
+
+func (assert *Assert) Accept(visitor Visitor) {
+	assert.Cond.Accept(visitor)
+	visitor.VisitInlineExpr(assert.Cond)
+	assert.Message.Accept(visitor)
+	visitor.VisitInlineExpr(assert.Message)
+	visitor.VisitAssert(assert)
+}
+
+
+type Import struct {
+	Path *Literal
+	Location *tokens.Location
+}
+
+func (import_ *Import) Loc() *tokens.Location {
+	return import_.Location
+}
+
+func (import_ *Import) Accept(visitor Visitor) {
+	visitor.VisitImport(import_)
+}
+
+
+type If struct {
+	Cond Expr
+	Then *Block
+	Else *Block
+	Location *tokens.Location
+}
+
+func (if_ *If) Loc() *tokens.Location {
+	return if_.Location
+}
+
+func (if_ *If) Accept(visitor Visitor) {
+	if_.Cond.Accept(visitor)
+	visitor.VisitInlineExpr(if_.Cond)
+	visitor.VisitPreIf(if_)
+	if_.Then.Accept(visitor)
+	visitor.VisitIfElse(if_)
+
+	if if_.Else != nil {
+		if_.Else.Accept(visitor)
+	}
+
+	visitor.VisitIf(if_)
+}
+
+
+type For struct {
+	Key *Ident
+	Value *Ident
+	Collection Expr
+	Block *Block
+	Location *tokens.Location
+}
+
+func (for_ *For) Loc() *tokens.Location {
+	return for_.Location
+}
+
+func (for_ *For) Accept(visitor Visitor) {
+	for_.Collection.Accept(visitor)
+	visitor.VisitInlineExpr(for_.Collection)
+	visitor.VisitPreFor(for_)
+	for_.Block.Accept(visitor)
+	visitor.VisitFor(for_)
+}
+
+
+type Reassign struct {
+	Target Expr
+	Append bool
+	Value Expr
+	Location *tokens.Location
+}
+
+func (reassign *Reassign) Loc() *tokens.Location {
+	return reassign.Location
+}
+
+func (reassign *Reassign) Accept(visitor Visitor) {
+	reassign.Value.Accept(visitor)
+	visitor.VisitInlineExpr(reassign.Value)
+
+	switch target := reassign.Target.(type) {
+	case *Member:
+		target.Object.Accept(visitor)
+		visitor.VisitInlineExpr(target.Object)
+		target.Field.Accept(visitor)
+		break
+	default:
+		target.Accept(visitor)
+		break
+	}
+
+	visitor.VisitReassign(reassign)
+}
+
+
 /**
  * Statement definitions
  */
@@ -102,3 +224,8 @@ func (section *Section) stmtNode() {}
 func (typedef *Typedef) stmtNode() {}
 func (block *Block) stmtNode() {}
 func (assign *Assign) stmtNode() {}
+func (assert *Assert) stmtNode() {}
+func (if_ *If) stmtNode() {}
+func (for_ *For) stmtNode() {}
+func (reassign *Reassign) stmtNode() {}
+func (import_ *Import) stmtNode() {}
@@ -7,16 +7,42 @@ type Visitor interface {
 	VisitPreInitialize(init *Initialize)
 	VisitInitialize(init *Initialize)
 	VisitInitializeField(initField *InitializeField)
+	VisitSpread(spread *Spread)
 	VisitLiteral(literal *Literal)
 	VisitBlock(block *Block)
 	VisitPreSection(section *Section)
 	VisitSection(section *Section)
 	VisitTypedef(typedef *Typedef)
 	VisitAssign(assign *Assign)
+	VisitReassign(reassign *Reassign)
 	VisitSource(source *Source)
 	VisitExprStmt(exprStmt *ExprStmt)
+	VisitAssert(assert *Assert)
+	VisitImport(import_ *Import)
 	VisitCall(call *Call)
 	VisitMember(member *Member)
+	VisitBinary(binary *Binary)
+	VisitIsNull(isNull *IsNull)
+	VisitNot(not *Not)
+	VisitPreConditional(conditional *Conditional)
+	VisitConditionalElse(conditional *Conditional)
+	VisitConditional(conditional *Conditional)
+	VisitPreIf(if_ *If)
+	VisitIfElse(if_ *If)
+	VisitIf(if_ *If)
+	VisitPreFor(for_ *For)
+	VisitFor(for_ *For)
+	VisitPreArray(array *Array)
+	VisitArrayElement(elem Expr)
+	VisitArray(array *Array)
+	VisitPreComprehension(comprehension *Comprehension)
+	VisitComprehensionLoop(comprehension *Comprehension)
+	VisitComprehensionFilter(comprehension *Comprehension)
+	VisitComprehension(comprehension *Comprehension)
+	VisitPreMatch(match *Match)
+	VisitPreMatchArm(arm *MatchArm)
+	VisitMatchArm(arm *MatchArm)
+	VisitMatch(match *Match)
 	VisitInlineExpr(expr Expr)
 }
 
@@ -0,0 +1,718 @@
+package checker
+
+import (
+	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
+)
+
+type Checker struct {
+	source *ast.Source
+	scope *Scope
+	diagnostics diag.Diagnostics
+}
+
+func NewChecker(source *ast.Source) *Checker {
+	return &Checker{
+		source: source,
+		scope: NewScope(nil),
+	}
+}
+
+func (checker *Checker) Declare(name string, type_ *Type) {
+	checker.scope.values[name] = &Binding{Type: type_, Const: true}
+}
+
+func (checker *Checker) Check() error {
+	checker.block(checker.source.Block)
+
+	if len(checker.diagnostics) > 0 {
+		return checker.diagnostics
+	}
+
+	return nil
+}
+
+func (checker *Checker) fail(code diag.Code, loc *tokens.Location, format string, args ...interface{}) *diag.Diagnostic {
+	diagnostic := diag.New(code, diag.At(loc), format, args...)
+	checker.diagnostics = append(checker.diagnostics, diagnostic)
+
+	return diagnostic
+}
+
+func (checker *Checker) openScope() {
+	checker.scope = NewScope(checker.scope)
+}
+
+func (checker *Checker) closeScope() *Scope {
+	scope := checker.scope
+	checker.scope = scope.parent
+
+	return scope
+}
+
+func (checker *Checker) expect(expr ast.Expr, expected *Type, context string) {
+	actual := checker.expr(expr)
+
+	if !actual.AssignableTo(expected) {
+		checker.fail(diag.TypeMismatch, expr.Loc(), "Expected %s to be of type `%s`, found `%s`", context, expected.FullName(), actual.FullName())
+	}
+}
+
+func (checker *Checker) resolveType(node *ast.Type) *Type {
+	var type_ *Type
+
+	switch node.Name.Value {
+	case "int":
+		type_ = Integer
+		break
+	case "bool":
+		type_ = Boolean
+		break
+	case "string":
+		type_ = String
+		break
+	case "float":
+		type_ = Float
+		break
+	case "object":
+		type_ = checker.objectType("object", node)
+		break
+	default:
+		type_ = checker.scope.LookupType(node.Name.Value)
+
+		if type_ == nil {
+			checker.fail(diag.UndefinedName, node.Loc(), "Undefined type `%s`", node.Name.Value)
+			return Unknown
+		}
+
+		break
+	}
+
+	if node.Array {
+		type_ = ArrayOf(type_)
+	}
+
+	if node.Optional {
+		type_ = type_.WithOptional(true)
+	}
+
+	return type_
+}
+
+func (checker *Checker) objectType(name string, node *ast.Type) *Type {
+	type_ := &Type{Kind: ObjectKind, Name: name}
+
+	if node.Base != nil {
+		base := checker.resolveType(node.Base)
+
+		if base.Kind == ObjectKind {
+			type_.Base = base
+			type_.Fields = append(type_.Fields, base.Fields...)
+		} else if base.Kind != UnknownKind {
+			checker.fail(diag.TypeMismatch, node.Base.Loc(), "Cannot extend non-object type `%s`", base.FullName())
+		}
+	}
+
+	for _, field := range node.Fields {
+		if type_.FieldByName(field.Name.Value) != nil {
+			checker.fail(diag.DuplicateField, field.Loc(), "Duplicate field `%s` in type `%s`", field.Name.Value, name)
+			continue
+		}
+
+		type_.Fields = append(type_.Fields, Field{
+			Name: field.Name.Value,
+			Type: checker.resolveType(field.Type),
+			Annotated: len(field.Annotations) > 0,
+		})
+	}
+
+	return type_
+}
+
+func (checker *Checker) block(block *ast.Block) {
+	for _, node := range block.Body {
+		checker.stmt(node)
+	}
+}
+
+func (checker *Checker) stmt(node ast.Node) {
+	switch stmt := node.(type) {
+	case *ast.Block:
+		checker.openScope()
+		checker.block(stmt)
+		checker.closeScope()
+		break
+	case *ast.Typedef:
+		checker.typedef(stmt)
+		break
+	case *ast.Assign:
+		checker.assign(stmt)
+		break
+	case *ast.Reassign:
+		checker.reassign(stmt)
+		break
+	case *ast.Section:
+		checker.section(stmt)
+		break
+	case *ast.Assert:
+		checker.expect(stmt.Cond, Boolean, "assertion")
+		checker.expect(stmt.Message, String, "assertion message")
+		break
+	case *ast.If:
+		checker.expect(stmt.Cond, Boolean, "condition")
+		checker.openScope()
+		checker.block(stmt.Then)
+		checker.closeScope()
+
+		if stmt.Else != nil {
+			checker.openScope()
+			checker.block(stmt.Else)
+			checker.closeScope()
+		}
+
+		break
+	case *ast.For:
+		checker.openScope()
+		checker.loop(stmt.Key, stmt.Value, stmt.Collection)
+		checker.block(stmt.Block)
+		checker.closeScope()
+		break
+	case *ast.ExprStmt:
+		checker.expr(stmt.Expr)
+		break
+	case *ast.Import:
+		break
+	}
+}
+
+func (checker *Checker) typedef(typedef *ast.Typedef) {
+	var type_ *Type
+
+	if typedef.Type.Name.Value == "object" {
+		type_ = checker.objectType(typedef.Name.Value, typedef.Type)
+	} else {
+		type_ = checker.resolveType(typedef.Type)
+	}
+
+	checker.scope.types[typedef.Name.Value] = type_
+}
+
+func (checker *Checker) assign(assign *ast.Assign) {
+	var declared *Type
+
+	if assign.Type != nil {
+		declared = checker.resolveType(assign.Type)
+	}
+
+	if assign.Value == nil {
+		if declared != nil && !declared.Optional && declared.Kind != ArrayKind {
+			checker.fail(diag.TypeMismatch, assign.Loc(), "Cannot declare `%s` of type `%s` without a value", assign.Name.Value, declared.FullName())
+		}
+	} else {
+		valueType := checker.expr(assign.Value)
+
+		if declared == nil {
+			declared = valueType
+		} else if !valueType.AssignableTo(declared) {
+			checker.fail(diag.TypeMismatch, assign.Value.Loc(), "Cannot store `%s` of type `%s` as type `%s`", assign.Name.Value, valueType.FullName(), declared.FullName())
+		}
+	}
+
+	if declared == nil {
+		declared = Unknown
+	}
+
+	checker.scope.values[assign.Name.Value] = &Binding{
+		Type: declared,
+		Const: assign.Const,
+	}
+}
+
+func (checker *Checker) reassign(reassign *ast.Reassign) {
+	var target *Type
+
+	switch node := reassign.Target.(type) {
+	case *ast.Ident:
+		binding := checker.scope.Lookup(node.Value)
+
+		if binding == nil {
+			checker.fail(diag.UndefinedName, node.Loc(), "Undefined name `%s`", node.Value)
+			checker.expr(reassign.Value)
+			return
+		} else if binding.Const {
+			checker.fail(diag.ConstantReassignment, node.Loc(), "Cannot assign to constant `%s`", node.Value)
+		}
+
+		target = binding.Type
+		break
+	case *ast.Member:
+		target = checker.member(node)
+		break
+	default:
+		target = checker.expr(node)
+		break
+	}
+
+	valueType := checker.expr(reassign.Value)
+
+	if reassign.Append {
+		if target.Kind != ArrayKind && target.Kind != UnknownKind {
+			checker.fail(diag.TypeMismatch, reassign.Loc(), "Cannot append to non-array type `%s`", target.FullName())
+		} else if target.Kind == ArrayKind && valueType.Kind != ArrayKind && target.Elem != nil && !valueType.AssignableTo(target.Elem) {
+			checker.fail(diag.TypeMismatch, reassign.Value.Loc(), "Cannot append `%s` to `%s`", valueType.FullName(), target.FullName())
+		} else if target.Kind == ArrayKind && valueType.Kind == ArrayKind && !valueType.AssignableTo(target) {
+			checker.fail(diag.TypeMismatch, reassign.Value.Loc(), "Cannot append `%s` to `%s`", valueType.FullName(), target.FullName())
+		}
+
+		return
+	}
+
+	if !valueType.AssignableTo(target) {
+		checker.fail(diag.TypeMismatch, reassign.Value.Loc(), "Cannot assign `%s` to `%s`", valueType.FullName(), target.FullName())
+	}
+}
+
+func (checker *Checker) section(section *ast.Section) {
+	var declared *Type
+	name := section.Name.Value
+	previous := checker.scope.values[name]
+
+	if section.Type != nil {
+		declared = checker.resolveType(section.Type)
+
+		if declared.Kind != ObjectKind && declared.Kind != UnknownKind {
+			checker.fail(diag.TypeMismatch, section.Type.Loc(), "Cannot use non-object type `%s` as section type", declared.FullName())
+			declared = Unknown
+		}
+	} else if previous != nil && checker.scope.sections[name] {
+		declared = previous.Type
+	}
+
+	checker.openScope()
+
+	if previous != nil && checker.scope.parent.sections[name] {
+		for _, field := range previous.Type.Fields {
+			checker.scope.values[field.Name] = &Binding{Type: field.Type}
+		}
+	}
+
+	checker.block(section.Block)
+	scope := checker.closeScope()
+
+	if declared == nil || declared.Name == "object" {
+		type_ := &Type{Kind: ObjectKind, Name: "object"}
+
+		if declared != nil {
+			type_.Fields = append(type_.Fields, declared.Fields...)
+		}
+
+		for _, node := range section.Block.Body {
+			if assign, isAssign := node.(*ast.Assign); isAssign && type_.FieldByName(assign.Name.Value) == nil {
+				type_.Fields = append(type_.Fields, Field{Name: assign.Name.Value, Type: scope.values[assign.Name.Value].Type})
+			} else if inner, isSection := node.(*ast.Section); isSection && type_.FieldByName(inner.Name.Value) == nil {
+				type_.Fields = append(type_.Fields, Field{Name: inner.Name.Value, Type: scope.values[inner.Name.Value].Type})
+			}
+		}
+
+		declared = type_
+	} else if declared.Kind == ObjectKind {
+		for _, node := range section.Block.Body {
+			var name string
+			var loc, valueLoc *tokens.Location
+
+			switch stmt := node.(type) {
+			case *ast.Assign:
+				name, loc, valueLoc = stmt.Name.Value, stmt.Loc(), stmt.Loc()
+
+				if stmt.Value != nil {
+					valueLoc = stmt.Value.Loc()
+				}
+
+				break
+			case *ast.Section:
+				name, loc, valueLoc = stmt.Name.Value, stmt.Loc(), stmt.Loc()
+				break
+			default:
+				continue
+			}
+
+			binding := scope.values[name]
+			field := declared.FieldByName(name)
+
+			if field == nil {
+				checker.fail(diag.UnknownField, loc, "Unknown field `%s` in section of type `%s`", name, declared.FullName())
+			} else if !binding.Type.AssignableTo(field.Type) {
+				checker.fail(diag.TypeMismatch, valueLoc, "Cannot store field `%s` of type `%s` as type `%s`", name, binding.Type.FullName(), field.Type.FullName())
+			}
+		}
+	}
+
+	checker.scope.values[name] = &Binding{Type: declared}
+	checker.scope.sections[name] = true
+}
+
+func (checker *Checker) loop(key *ast.Ident, value *ast.Ident, collection ast.Expr) {
+	keyType, valueType := Unknown, Unknown
+	collectionType := checker.expr(collection)
+
+	switch collectionType.Kind {
+	case ArrayKind:
+		keyType = Integer
+
+		if collectionType.Elem != nil {
+			valueType = collectionType.Elem
+		}
+
+		break
+	case ObjectKind:
+		keyType = String
+		break
+	case UnknownKind:
+		break
+	default:
+		checker.fail(diag.TypeMismatch, collection.Loc(), "Cannot iterate over `%s`", collectionType.FullName())
+		break
+	}
+
+	if key != nil {
+		checker.scope.values[key.Value] = &Binding{Type: keyType}
+	}
+
+	checker.scope.values[value.Value] = &Binding{Type: valueType}
+}
+
+func (checker *Checker) expr(expr ast.Expr) *Type {
+	switch node := expr.(type) {
+	case *ast.Literal:
+		return checker.literal(node)
+	case *ast.Ident:
+		binding := checker.scope.Lookup(node.Value)
+
+		if binding == nil {
+			checker.fail(diag.UndefinedName, node.Loc(), "Undefined name `%s`", node.Value)
+			return Unknown
+		}
+
+		return binding.Type
+	case *ast.Member:
+		return checker.member(node)
+	case *ast.Call:
+		return checker.call(node)
+	case *ast.Initialize:
+		return checker.initialize(node)
+	case *ast.Binary:
+		return checker.binary(node)
+	case *ast.IsNull:
+		checker.expr(node.Value)
+		return Boolean
+	case *ast.Not:
+		checker.expect(node.Value, Boolean, "operand of `!`")
+		return Boolean
+	case *ast.Conditional:
+		checker.expect(node.Cond, Boolean, "condition")
+		return checker.unify(node.Loc(), "Conditional branches", []*Type{checker.expr(node.Then), checker.expr(node.Else)})
+	case *ast.Array:
+		elements := []*Type{}
+
+		for _, elem := range node.Elements {
+			elements = append(elements, checker.expr(elem))
+		}
+
+		if len(elements) == 0 {
+			return ArrayOf(nil)
+		}
+
+		return ArrayOf(checker.unify(node.Loc(), "Array elements", elements))
+	case *ast.Comprehension:
+		checker.openScope()
+		checker.loop(node.Key, node.Value, node.Collection)
+
+		if node.Filter != nil {
+			checker.expect(node.Filter, Boolean, "filter")
+		}
+
+		result := checker.expr(node.Result)
+		checker.closeScope()
+
+		return ArrayOf(result)
+	case *ast.Match:
+		return checker.match(node)
+	}
+
+	return Unknown
+}
+
+func (checker *Checker) literal(literal *ast.Literal) *Type {
+	switch literal.Type {
+	case ast.Integer:
+		return Integer
+	case ast.Float:
+		return Float
+	case ast.Boolean:
+		return Boolean
+	case ast.Null:
+		return Null
+	}
+
+	return String
+}
+
+func (checker *Checker) unify(loc *tokens.Location, context string, types []*Type) *Type {
+	var result *Type
+	optional := false
+
+	for _, type_ := range types {
+		if type_.Kind == NullKind {
+			optional = true
+			continue
+		} else if result == nil || result.Kind == UnknownKind {
+			result = type_
+			continue
+		}
+
+		if type_.AssignableTo(result) {
+			continue
+		} else if result.AssignableTo(type_) {
+			result = type_
+			continue
+		}
+
+		checker.fail(diag.TypeMismatch, loc, "%s have incompatible types `%s` and `%s`", context, result.FullName(), type_.FullName())
+		return Unknown
+	}
+
+	if result == nil {
+		return Null
+	} else if optional {
+		return result.WithOptional(true)
+	}
+
+	return result
+}
+
+func (checker *Checker) member(member *ast.Member) *Type {
+	objectType := checker.expr(member.Object)
+	name := member.Field.(*ast.Ident).Value
+
+	if objectType.Kind == UnknownKind {
+		return Unknown
+	} else if objectType.Kind == NullKind {
+		if !member.Optional {
+			checker.fail(diag.TypeMismatch, member.Loc(), "Cannot read field `%s` of null", name)
+		}
+
+		return Null
+	} else if objectType.Kind != ObjectKind {
+		checker.fail(diag.TypeMismatch, member.Loc(), "Cannot read field `%s` of non-object type `%s`", name, objectType.FullName())
+		return Unknown
+	} else if objectType.Optional && !member.Optional {
+		checker.fail(diag.TypeMismatch, member.Loc(), "Cannot read field `%s` of optional type `%s`, use `?.`", name, objectType.FullName())
+	}
+
+	field := objectType.FieldByName(name)
+
+	if field == nil {
+		checker.fail(diag.UnknownField, member.Field.Loc(), "Unknown field `%s` in type `%s`", name, objectType.FullName())
+		return Unknown
+	} else if objectType.Optional {
+		return field.Type.WithOptional(true)
+	}
+
+	return field.Type
+}
+
+func (checker *Checker) call(call *ast.Call) *Type {
+	args := []*Type{}
+	var callee *Type
+
+	if member, isMember := call.Callee.(*ast.Member); isMember {
+		objectType := checker.expr(member.Object)
+		name := member.Field.(*ast.Ident).Value
+
+		if objectType.Kind == UnknownKind {
+			callee = Unknown
+		} else if binding := checker.scope.Lookup(objectType.Name + "_" + name); binding != nil {
+			callee = binding.Type
+			args = append(args, objectType)
+		} else {
+			checker.fail(diag.InvalidCall, member.Field.Loc(), "Unknown method `%s` on type `%s`", name, objectType.FullName())
+			callee = Unknown
+		}
+	} else {
+		callee = checker.expr(call.Callee)
+	}
+
+	for _, arg := range call.Args {
+		args = append(args, checker.expr(arg))
+	}
+
+	if callee.Kind == UnknownKind {
+		return Unknown
+	} else if callee.Kind != FunctionKind {
+		checker.fail(diag.InvalidCall, call.Loc(), "Cannot call non-function type `%s`", callee.FullName())
+		return Unknown
+	} else if len(args) != len(callee.Params) {
+		checker.fail(diag.InvalidCall, call.Loc(), "Expected %d argument(s), found %d", len(callee.Params), len(args))
+		return Unknown
+	}
+
+	generics := map[string]*Type{}
+
+	for i, arg := range args {
+		if !checker.bindParam(callee.Params[i], arg, generics) {
+			loc := call.Loc()
+
+			if offset := len(args) - len(call.Args); i >= offset {
+				loc = call.Args[i - offset].Loc()
+			}
+
+			checker.fail(diag.TypeMismatch, loc, "Cannot use `%s` as argument of type `%s`", arg.FullName(), checker.instantiate(callee.Params[i], generics).FullName())
+		}
+	}
+
+	// Builtins don't return anything, the vm always pushes null after a call
+	return Null
+}
+
+func (checker *Checker) bindParam(param *Type, arg *Type, generics map[string]*Type) bool {
+	if param.Kind == GenericKind {
+		if bound, exist := generics[param.Name]; exist {
+			return arg.AssignableTo(bound)
+		}
+
+		if arg.Kind != NullKind {
+			generics[param.Name] = arg
+		}
+
+		return true
+	} else if param.Kind == ArrayKind && arg.Kind == ArrayKind && param.Elem != nil {
+		return arg.Elem == nil || checker.bindParam(param.Elem, arg.Elem, generics)
+	}
+
+	return arg.AssignableTo(param)
+}
+
+func (checker *Checker) instantiate(param *Type, generics map[string]*Type) *Type {
+	if param.Kind == GenericKind && generics[param.Name] != nil {
+		return generics[param.Name]
+	} else if param.Kind == ArrayKind && param.Elem != nil {
+		return ArrayOf(checker.instantiate(param.Elem, generics))
+	}
+
+	return param
+}
+
+func (checker *Checker) initialize(init *ast.Initialize) *Type {
+	var type_ *Type
+
+	if init.Type != nil {
+		type_ = checker.resolveType(init.Type)
+	}
+
+	for _, spread := range init.Spreads {
+		spreadType := checker.expr(spread.Value)
+
+		if spreadType.Kind != ObjectKind && spreadType.Kind != UnknownKind {
+			checker.fail(diag.TypeMismatch, spread.Loc(), "Cannot spread non-object type `%s`", spreadType.FullName())
+		} else if type_ == nil {
+			type_ = spreadType.WithOptional(false)
+		} else if !type_.AssignableTo(spreadType.WithOptional(false)) {
+			checker.fail(diag.TypeMismatch, spread.Loc(), "Cannot spread `%s` into `%s`", spreadType.FullName(), type_.FullName())
+		}
+	}
+
+	if type_ == nil || type_.Kind == UnknownKind {
+		for _, field := range init.Fields {
+			checker.expr(field.Value)
+		}
+
+		return Unknown
+	} else if type_.Kind != ObjectKind {
+		checker.fail(diag.TypeMismatch, init.Type.Loc(), "Cannot initialize non-object type `%s`", type_.FullName())
+		return Unknown
+	}
+
+	set := map[string]bool{}
+
+	for _, initField := range init.Fields {
+		valueType := checker.expr(initField.Value)
+		field := type_.FieldByName(initField.Name.Value)
+		set[initField.Name.Value] = true
+
+		if field == nil {
+			checker.fail(diag.UnknownField, initField.Loc(), "Unknown field `%s` in type `%s`", initField.Name.Value, type_.FullName())
+		} else if !valueType.AssignableTo(field.Type) {
+			checker.fail(diag.TypeMismatch, initField.Value.Loc(), "Cannot store field `%s` of type `%s` as type `%s`", field.Name, valueType.FullName(), field.Type.FullName())
+		}
+	}
+
+	if len(init.Spreads) == 0 {
+		for _, field := range type_.Fields {
+			if !set[field.Name] && !field.Type.Optional && !field.Annotated && field.Type.Kind != ArrayKind {
+				checker.fail(diag.MissingField, init.Loc(), "Missing field `%s` in initializer of type `%s`", field.Name, type_.FullName())
+			}
+		}
+	}
+
+	return type_.WithOptional(false)
+}
+
+func (checker *Checker) binary(binary *ast.Binary) *Type {
+	left := checker.expr(binary.Left)
+	right := checker.expr(binary.Right)
+
+	switch binary.Op {
+	case ast.Coalesce:
+		if left.Kind == NullKind || left.Kind == UnknownKind {
+			return right
+		} else if !right.AssignableTo(left.WithOptional(true)) {
+			checker.fail(diag.TypeMismatch, binary.Right.Loc(), "Cannot coalesce `%s` with `%s`", left.FullName(), right.FullName())
+			return Unknown
+		}
+
+		return left.WithOptional(right.Optional)
+	}
+
+	return Boolean
+}
+
+func (checker *Checker) match(match *ast.Match) *Type {
+	checker.expr(match.Value)
+	results := []*Type{}
+
+	for _, arm := range match.Arms {
+		checker.openScope()
+		checker.pattern(arm.Pattern)
+		results = append(results, checker.expr(arm.Result))
+		checker.closeScope()
+	}
+
+	return checker.unify(match.Loc(), "Match arms", results)
+}
+
+func (checker *Checker) pattern(node ast.Pattern) {
+	typePattern, isTypePattern := node.(*ast.TypePattern)
+
+	if !isTypePattern {
+		return
+	}
+
+	type_ := checker.resolveType(typePattern.Type)
+
+	if type_.Kind != ObjectKind {
+		return
+	}
+
+	for _, fieldPattern := range typePattern.Fields {
+		field := type_.FieldByName(fieldPattern.Name.Value)
+
+		if field == nil {
+			checker.fail(diag.UnknownField, fieldPattern.Name.Loc(), "Unknown field `%s` in type `%s`", fieldPattern.Name.Value, type_.FullName())
+		} else if fieldPattern.Pattern == nil {
+			checker.scope.values[fieldPattern.Name.Value] = &Binding{Type: field.Type}
+		} else {
+			checker.pattern(fieldPattern.Pattern)
+		}
+	}
+}
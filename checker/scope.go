@@ -0,0 +1,42 @@
+package checker
+
+type Binding struct {
+	Type *Type
+	Const bool
+}
+
+type Scope struct {
+	parent *Scope
+	values map[string]*Binding
+	types map[string]*Type
+	sections map[string]bool
+}
+
+func NewScope(parent *Scope) *Scope {
+	return &Scope{
+		parent: parent,
+		values: map[string]*Binding{},
+		types: map[string]*Type{},
+		sections: map[string]bool{},
+	}
+}
+
+func (scope *Scope) Lookup(name string) *Binding {
+	if binding, exist := scope.values[name]; exist {
+		return binding
+	} else if scope.parent != nil {
+		return scope.parent.Lookup(name)
+	}
+
+	return nil
+}
+
+func (scope *Scope) LookupType(name string) *Type {
+	if type_, exist := scope.types[name]; exist {
+		return type_
+	} else if scope.parent != nil {
+		return scope.parent.LookupType(name)
+	}
+
+	return nil
+}
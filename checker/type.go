@@ -0,0 +1,113 @@
+package checker
+
+type Kind int
+
+const (
+	UnknownKind Kind = iota
+	StringKind
+	IntegerKind
+	FloatKind
+	BooleanKind
+	NullKind
+	ArrayKind
+	ObjectKind
+	FunctionKind
+	GenericKind
+)
+
+type Field struct {
+	Name string
+	Type *Type
+	Annotated bool
+}
+
+type Type struct {
+	Kind Kind
+	Name string
+	Optional bool
+	Elem *Type
+	Base *Type
+	Fields []Field
+	Params []*Type
+}
+
+var (
+	Unknown = &Type{Kind: UnknownKind, Name: "unknown"}
+	String = &Type{Kind: StringKind, Name: "string"}
+	Integer = &Type{Kind: IntegerKind, Name: "int"}
+	Float = &Type{Kind: FloatKind, Name: "float"}
+	Boolean = &Type{Kind: BooleanKind, Name: "bool"}
+	Null = &Type{Kind: NullKind, Name: "null", Optional: true}
+)
+
+func ArrayOf(elem *Type) *Type {
+	return &Type{Kind: ArrayKind, Name: "array", Elem: elem}
+}
+
+func FunctionOf(params ...*Type) *Type {
+	return &Type{Kind: FunctionKind, Name: "function", Params: params}
+}
+
+func Generic(name string) *Type {
+	return &Type{Kind: GenericKind, Name: name}
+}
+
+func (type_ *Type) FullName() string {
+	typeName := type_.Name
+
+	if type_.Kind == ArrayKind && type_.Elem == nil {
+		typeName = "[]"
+	} else if type_.Kind == ArrayKind {
+		typeName = "[]" + type_.Elem.FullName()
+	}
+
+	if type_.Optional && type_.Kind != NullKind {
+		typeName += "?"
+	}
+
+	return typeName
+}
+
+func (type_ *Type) WithOptional(optional bool) *Type {
+	copied := *type_
+	copied.Optional = optional
+	return &copied
+}
+
+func (type_ *Type) FieldByName(name string) *Field {
+	for i := range type_.Fields {
+		if type_.Fields[i].Name == name {
+			return &type_.Fields[i]
+		}
+	}
+
+	return nil
+}
+
+func (type_ *Type) Equals(otherType *Type) bool {
+	if type_.Kind != otherType.Kind || type_.Name != otherType.Name {
+		return false
+	} else if type_.Kind == ArrayKind && type_.Elem != nil && otherType.Elem != nil {
+		return type_.Elem.Equals(otherType.Elem)
+	}
+
+	return true
+}
+
+func (type_ *Type) AssignableTo(otherType *Type) bool {
+	if type_.Kind == UnknownKind || otherType.Kind == UnknownKind {
+		return true
+	} else if type_.Kind == NullKind {
+		return otherType.Optional
+	} else if type_.Optional && !otherType.Optional {
+		return false
+	} else if type_.Kind == ArrayKind && otherType.Kind == ArrayKind {
+		return type_.Elem == nil || otherType.Elem == nil || type_.Elem.AssignableTo(otherType.Elem)
+	} else if type_.Equals(otherType) {
+		return true
+	} else if type_.Kind == ObjectKind && type_.Base != nil {
+		return type_.Base.WithOptional(type_.Optional).AssignableTo(otherType)
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkSource(t *testing.T, input string) diag.Diagnostics {
+	source, errLexer, errParser := tokenizeAndParse(input)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		t.FailNow()
+	}
+
+	typeChecker := checker.NewChecker(source)
+	declareBuiltins(typeChecker)
+
+	return diag.From(typeChecker.Check())
+}
+
+func TestCheckValid(t *testing.T) {
+	diagnostics := checkSource(t, filesystemType + `
+	let root: Filesystem = new {
+		...base
+		opts = "defaults"
+	}
+	let paths = [fs.path for fs in [base, root] if fs.opts == null]
+	let opts: string = root.opts ?? "defaults"
+	let label = match root {
+		Filesystem { path = "/" } => "root"
+		_ => "other"
+	}
+	paths.add("/home")
+	writeln(label)`)
+
+	assert.Nil(t, diagnostics, "Valid program shouldn't report type errors")
+}
+
+func TestCheckErrors(t *testing.T) {
+	diagnostics := checkSource(t, filesystemType + `
+	let size: int = "large"
+	let home: Filesystem = new {
+		uuid = "home"
+		path = 10
+		mode = "rw"
+	}
+	let opts: string = base.opts
+	const ids = [1, 2]
+	ids = [3]
+	ids.add("four")
+	assert base.path, "path must be set"
+	writeln(missing)
+	let disk: Disk = null`)
+
+	if !assert.Equal(t, 10, len(diagnostics), "All type errors should be reported") {
+		t.Log(diagnostics)
+		return
+	}
+
+	assert.Equal(t, diag.TypeMismatch, diagnostics[0].Code)
+	assert.Equal(t, "Cannot store `size` of type `string` as type `int`", diagnostics[0].Message)
+	assert.Equal(t, 14, diagnostics[0].Span.Line)
+	assert.Equal(t, diag.TypeMismatch, diagnostics[1].Code)
+	assert.Equal(t, diag.UnknownField, diagnostics[2].Code)
+	assert.Equal(t, "Unknown field `mode` in type `Filesystem`", diagnostics[2].Message)
+	assert.Equal(t, diag.MissingField, diagnostics[3].Code)
+	assert.Equal(t, "Missing field `fstype` in initializer of type `Filesystem`", diagnostics[3].Message)
+	assert.Equal(t, diag.TypeMismatch, diagnostics[4].Code)
+	assert.Equal(t, "Cannot store `opts` of type `string?` as type `string`", diagnostics[4].Message)
+	assert.Equal(t, diag.ConstantReassignment, diagnostics[5].Code)
+	assert.Equal(t, "Cannot assign to constant `ids`", diagnostics[5].Message)
+	assert.Equal(t, "Cannot use `string` as argument of type `int`", diagnostics[6].Message)
+	assert.Equal(t, "Expected assertion to be of type `bool`, found `string`", diagnostics[7].Message)
+	assert.Equal(t, diag.UndefinedName, diagnostics[8].Code)
+	assert.Equal(t, "Undefined name `missing`", diagnostics[8].Message)
+	assert.Equal(t, diag.UndefinedName, diagnostics[9].Code)
+	assert.Equal(t, "Undefined type `Disk`", diagnostics[9].Message)
+}
+
+func TestCheckSectionFields(t *testing.T) {
+	diagnostics := checkSource(t, filesystemType + `
+	root: Filesystem {
+		let uuid = "root"
+		let path = 10
+		let fstype = "ext4"
+		let mode = "rw"
+	}`)
+
+	if !assert.Equal(t, 2, len(diagnostics), "All section field errors should be reported") {
+		t.Log(diagnostics)
+		return
+	}
+
+	assert.Equal(t, diag.TypeMismatch, diagnostics[0].Code)
+	assert.Equal(t, "Cannot store field `path` of type `int` as type `string`", diagnostics[0].Message)
+	assert.Equal(t, 16, diagnostics[0].Span.Line, "Errors should point at the field's value")
+	assert.Equal(t, diag.UnknownField, diagnostics[1].Code)
+	assert.Equal(t, "Unknown field `mode` in section of type `Filesystem`", diagnostics[1].Message)
+	assert.Equal(t, 18, diagnostics[1].Span.Line, "Errors should point at the field's assignment")
+}
+
+func TestCheckCalls(t *testing.T) {
+	diagnostics := checkSource(t, `let paths: []string = ["/"]
+	let result = writeln("/")
+	let path: string = writeln("/")
+	paths.add(10)
+	writeln("a", "b")`)
+
+	if !assert.Equal(t, 3, len(diagnostics), "All call errors should be reported") {
+		t.Log(diagnostics)
+		return
+	}
+
+	assert.Equal(t, diag.TypeMismatch, diagnostics[0].Code)
+	assert.Equal(t, "Cannot store `path` of type `null` as type `string`", diagnostics[0].Message, "Calls should evaluate to null")
+	assert.Equal(t, diag.TypeMismatch, diagnostics[1].Code)
+	assert.Equal(t, "Cannot use `int` as argument of type `string`", diagnostics[1].Message)
+	assert.Equal(t, 4, diagnostics[1].Span.Line)
+	assert.Equal(t, diag.InvalidCall, diagnostics[2].Code)
+	assert.Equal(t, "Expected 1 argument(s), found 2", diagnostics[2].Message)
+}
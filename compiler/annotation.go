@@ -0,0 +1,27 @@
+package compiler
+
+import "dmeijboom/config/ast"
+
+type Annotation struct {
+	Name string
+	Args []interface{}
+}
+
+func annotations(nodes []ast.Annotation) []Annotation {
+	annotations := []Annotation{}
+
+	for _, node := range nodes {
+		annotation := Annotation{
+			Name: node.Name.Value,
+			Args: []interface{}{},
+		}
+
+		for _, arg := range node.Args {
+			annotation.Args = append(annotation.Args, arg.Value)
+		}
+
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations
+}
@@ -2,26 +2,220 @@ package compiler
 
 import (
 	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
 )
 
 var builtinTypes = []string{
 	"int", "bool", "string", "float",
 }
 
+type scope struct {
+	parent *scope
+	index int32
+	slots map[string]int32
+	sections map[string]*scope
+}
+
+
 type Compiler struct {
+	err *diag.Diagnostic
 	source *ast.Source
-	instructions []Instruction
+	scope *scope
+	jumps []int
+	loops []int
+	names map[string]int32
+	constants map[interface{}]int32
+	program *Program
+	// Optimize folds constants and removes dead code before and after
+	// compiling, note that it rewrites the source AST in place
+	Optimize bool
 }
 
 func NewCompiler(source *ast.Source) *Compiler {
 	return &Compiler{
 		source: source,
-		instructions: []Instruction{},
+		names: map[string]int32{},
+		constants: map[interface{}]int32{},
+		program: &Program{
+			Code: []int32{},
+			Constants: []interface{}{},
+			Names: []string{},
+			Scopes: []Scope{},
+			Lines: []Line{},
+		},
+		Optimize: true,
+	}
+}
+
+func (compiler *Compiler) openScope(seed *scope) int32 {
+	scope := &scope{
+		parent: compiler.scope,
+		index: int32(len(compiler.program.Scopes)),
+		slots: map[string]int32{},
+		sections: map[string]*scope{},
+	}
+
+	compiler.program.Scopes = append(compiler.program.Scopes, Scope{Names: []string{}})
+	compiler.scope = scope
+
+	// A reopened section starts with the layout of the previous one so merged fields keep their slots
+	if seed != nil {
+		for _, name := range compiler.program.Scopes[seed.index].Names {
+			compiler.declare(name)
+		}
+
+		for name, section := range seed.sections {
+			scope.sections[name] = section
+		}
 	}
+
+	return scope.index
 }
 
-func (compiler *Compiler) add(instruction Instruction) {
-	compiler.instructions = append(compiler.instructions, instruction)
+func (compiler *Compiler) closeScope() *scope {
+	scope := compiler.scope
+	compiler.scope = scope.parent
+	return scope
+}
+
+func (compiler *Compiler) declare(name string) int32 {
+	if slot, exist := compiler.scope.slots[name]; exist {
+		return slot
+	}
+
+	layout := &compiler.program.Scopes[compiler.scope.index]
+	slot := int32(len(layout.Names))
+	layout.Names = append(layout.Names, name)
+	compiler.scope.slots[name] = slot
+	return slot
+}
+
+func (compiler *Compiler) resolve(name string) (int32, int32, bool) {
+	depth := int32(0)
+
+	for scope := compiler.scope; scope != nil; scope = scope.parent {
+		if slot, exist := scope.slots[name]; exist {
+			return depth, slot, true
+		}
+
+		depth++
+	}
+
+	return 0, 0, false
+}
+
+func (compiler *Compiler) emit(loc *tokens.Location, op Opcode, operands ...int32) int {
+	if len(operands) != len(op.Info().Operands) {
+		panic("Invalid operand count for " + op.String())
+	}
+
+	offset := len(compiler.program.Code)
+	location := tokens.Location{}
+
+	if loc != nil {
+		location = *loc
+	}
+
+	if lines := compiler.program.Lines; len(lines) == 0 || lines[len(lines)-1].Location != location {
+		compiler.program.Lines = append(lines, Line{Offset: int32(offset), Location: location})
+	}
+
+	compiler.program.Code = append(compiler.program.Code, int32(op))
+	compiler.program.Code = append(compiler.program.Code, operands...)
+	return offset
+}
+
+func (compiler *Compiler) emitJump(loc *tokens.Location, op Opcode, operands ...int32) {
+	compiler.jumps = append(compiler.jumps, compiler.emit(loc, op, operands...))
+}
+
+func (compiler *Compiler) patchJump() {
+	offset := compiler.jumps[len(compiler.jumps)-1]
+	compiler.jumps = compiler.jumps[:len(compiler.jumps)-1]
+	compiler.program.Code[offset + 1] = int32(len(compiler.program.Code))
+}
+
+func (compiler *Compiler) name(name string) int32 {
+	if index, exist := compiler.names[name]; exist {
+		return index
+	}
+
+	index := int32(len(compiler.program.Names))
+	compiler.program.Names = append(compiler.program.Names, name)
+	compiler.names[name] = index
+	return index
+}
+
+func (compiler *Compiler) constant(value interface{}) int32 {
+	switch value.(type) {
+	case nil, int, float64, string, bool:
+		if index, exist := compiler.constants[value]; exist {
+			return index
+		}
+
+		compiler.constants[value] = int32(len(compiler.program.Constants))
+		break
+	}
+
+	compiler.program.Constants = append(compiler.program.Constants, value)
+	return int32(len(compiler.program.Constants) - 1)
+}
+
+func (compiler *Compiler) doc(doc string) int32 {
+	if doc == "" {
+		return -1
+	}
+
+	return compiler.constant(doc)
+}
+
+func (compiler *Compiler) annotations(nodes []ast.Annotation) int32 {
+	if len(nodes) == 0 {
+		return -1
+	}
+
+	return compiler.constant(annotations(nodes))
+}
+
+func (compiler *Compiler) openLoop(key *ast.Ident, value *ast.Ident, loc *tokens.Location) {
+	compiler.emit(loc, OpGetIter)
+	compiler.loops = append(compiler.loops, len(compiler.program.Code))
+	compiler.emitJump(loc, OpForIter, -1, int32(btoi(key != nil)))
+	compiler.emit(loc, OpPushFrame, compiler.openScope(nil))
+
+	for _, ident := range []*ast.Ident{value, key} {
+		if ident != nil {
+			compiler.emit(ident.Loc(), OpStoreVal, compiler.declare(ident.Value), int32(StoreValue), -1)
+		}
+	}
+}
+
+func (compiler *Compiler) closeLoop(loc *tokens.Location) {
+	start := compiler.loops[len(compiler.loops)-1]
+	compiler.loops = compiler.loops[:len(compiler.loops)-1]
+
+	compiler.closeScope()
+	compiler.emit(loc, OpPopFrame)
+	compiler.emit(loc, OpJump, int32(start))
+	compiler.patchJump()
+}
+
+func (compiler *Compiler) jumpElse(loc *tokens.Location) {
+	offset := compiler.emit(loc, OpJump, -1)
+
+	compiler.patchJump()
+	compiler.jumps = append(compiler.jumps, offset)
+}
+
+func (compiler *Compiler) fail(code diag.Code, loc *tokens.Location, format string, args ...interface{}) *diag.Diagnostic {
+	diagnostic := diag.New(code, diag.At(loc), format, args...)
+
+	if compiler.err == nil {
+		compiler.err = diagnostic
+	}
+
+	return diagnostic
 }
 
 func (compiler *Compiler) isBuiltin(name string) bool {
@@ -35,79 +229,103 @@ func (compiler *Compiler) isBuiltin(name string) bool {
 }
 
 func (compiler *Compiler) VisitIdent(ident *ast.Ident) {
-	compiler.add(&LoadName{
-		Name: ident.Value,
-		Location: ident.Loc(),
-	})
+
 }
 
 func (compiler *Compiler) VisitField(field *ast.Field) {
-	compiler.add(&MakeField{
-		Location: field.Loc(),
-	})
+	compiler.emit(field.Loc(), OpMakeField, compiler.name(field.Name.Value), compiler.doc(field.Doc), compiler.annotations(field.Annotations))
 }
 
 func (compiler *Compiler) VisitType(node *ast.Type) {
 	if node.Name.Value == "object" {
-		compiler.add(&MakeObject{
-			Fields: len(node.Fields),
-			Location: node.Loc(),
-		})
+		compiler.emit(node.Loc(), OpMakeObject, int32(len(node.Fields)), int32(btoi(node.Base != nil)))
 		return
 	}
 
-	loadType := &LoadType{
-		Array: node.Array,
-		Optional: node.Optional,
-		Location: node.Loc(),
+	typeId := compiler.typeId(node.Name.Value)
+	flags := TypeFlags(0)
+	name := int32(-1)
+
+	if node.Array {
+		flags |= TypeArray
+	}
+
+	if node.Optional {
+		flags |= TypeOptional
 	}
 
-	if compiler.isBuiltin(node.Name.Value) {
-		switch node.Name.Value {
+	if typeId == UserType {
+		name = compiler.name(node.Name.Value)
+	}
+
+	compiler.emit(node.Loc(), OpLoadType, int32(typeId), int32(flags), name)
+}
+
+func (compiler *Compiler) typeId(name string) TypeId {
+	if compiler.isBuiltin(name) {
+		switch name {
 		case "int":
-			loadType.Type = IntegerType
-			break
+			return IntegerType
 		case "bool":
-			loadType.Type = BooleanType
-			break
+			return BooleanType
 		case "string":
-			loadType.Type = StringType
-			break
+			return StringType
 		case "float":
-			loadType.Type = FloatType
-			break
+			return FloatType
 		}
-	} else {
-		loadType.Type = UserType
 	}
 
-	compiler.add(loadType)
+	return UserType
 }
 
 func (compiler *Compiler) VisitPreInitialize(init *ast.Initialize) {
-	compiler.add(&NewObject{
-		Fields: len(init.Fields),
-		Location: init.Loc(),
-	})
+	names := map[string]*ast.InitializeField{}
+
+	for i, field := range init.Fields {
+		if previous, exist := names[field.Name.Value]; exist {
+			compiler.fail(diag.DuplicateField, field.Loc(), "Duplicate field `%s` in initializer", field.Name.Value).
+				WithLabel(diag.At(previous.Loc()), "first set here")
+		}
+
+		names[field.Name.Value] = &init.Fields[i]
+	}
+
+	if init.Type == nil && len(init.Spreads) == 0 {
+		compiler.fail(diag.UninferableType, init.Loc(), "Cannot infer the type of an object initializer, use `new <type> { ... }`")
+	}
+
+	compiler.emit(init.Loc(), OpNewObject, int32(btoi(init.Type != nil)))
 }
 
 func (compiler *Compiler) VisitInitialize(init *ast.Initialize) {
-	compiler.add(&Initialize{
-		Location: init.Loc(),
-	})
+	compiler.emit(init.Loc(), OpInitialize)
+}
+
+func (compiler *Compiler) VisitSpread(spread *ast.Spread) {
+	compiler.emit(spread.Loc(), OpSpreadObject)
 }
 
 func (compiler *Compiler) VisitInitializeField(initField *ast.InitializeField) {
-	compiler.add(&SetField{
-		Location: initField.Loc(),
-	})
+	compiler.emit(initField.Loc(), OpSetField, compiler.name(initField.Name.Value))
+}
+
+func (compiler *Compiler) literalType(literal *ast.Literal) TypeId {
+	switch literal.Type {
+	case ast.Integer:
+		return IntegerType
+	case ast.Float:
+		return FloatType
+	case ast.Boolean:
+		return BooleanType
+	case ast.Null:
+		return NullType
+	}
+
+	return StringType
 }
 
 func (compiler *Compiler) VisitLiteral(literal *ast.Literal) {
-	compiler.add(&LoadConst{
-		Value: literal.Value,
-		Location: literal.Loc(),
-	})
+	compiler.emit(literal.Loc(), OpLoadConst, compiler.constant(literal.Value))
 }
 
 func (compiler *Compiler) VisitBlock(block *ast.Block) {
@@ -115,53 +333,280 @@ func (compiler *Compiler) VisitBlock(block *ast.Block) {
 }
 
 func (compiler *Compiler) VisitPreSection(section *ast.Section) {
-	compiler.add(&OpenSection{
-		Location: section.Loc(),
-	})
+	name := section.Name.Value
+	index := compiler.openScope(compiler.scope.sections[name])
+
+	compiler.emit(section.Loc(), OpOpenSection, compiler.name(name), int32(btoi(section.Type != nil)), index)
 }
 
 func (compiler *Compiler) VisitSection(section *ast.Section) {
-	compiler.add(&CloseSection{
-		Location: section.Loc(),
-	})
+	name := section.Name.Value
+	body := compiler.closeScope()
+
+	compiler.scope.sections[name] = body
+	compiler.emit(section.Loc(), OpCloseSection, compiler.declare(name))
 }
 
 func (compiler *Compiler) VisitTypedef(typedef *ast.Typedef) {
-	compiler.add(&MakeType{
-		Location: typedef.Loc(),
-	})
+	compiler.emit(typedef.Loc(), OpMakeType, compiler.name(typedef.Name.Value), compiler.doc(typedef.Doc), compiler.annotations(typedef.Annotations))
 }
 
 func (compiler *Compiler) VisitAssign(assign *ast.Assign) {
-	compiler.add(&StoreVal{
-		HasValue: assign.Value != nil,
-		Location: assign.Loc(),
-	})
+	flags := StoreFlags(0)
+
+	if assign.Const {
+		flags |= StoreConst
+	}
+
+	if assign.Type != nil {
+		flags |= StoreTyped
+	}
+
+	if assign.Value != nil {
+		flags |= StoreValue
+	}
+
+	compiler.emit(assign.Loc(), OpStoreVal, compiler.declare(assign.Name.Value), int32(flags), compiler.annotations(assign.Annotations))
+}
+
+func (compiler *Compiler) VisitImport(import_ *ast.Import) {
+	compiler.fail(diag.UnresolvedImport, import_.Loc(), "Unresolved import `%s`", import_.Path.Value)
+}
+
+func (compiler *Compiler) VisitReassign(reassign *ast.Reassign) {
+	switch target := reassign.Target.(type) {
+	case *ast.Member:
+		compiler.emit(reassign.Loc(), OpSetMember, compiler.name(target.Field.(*ast.Ident).Value), int32(btoi(reassign.Append)))
+		break
+	case *ast.Ident:
+		if depth, slot, found := compiler.resolve(target.Value); found {
+			compiler.emit(reassign.Loc(), OpReassignSlot, depth, slot, int32(btoi(reassign.Append)))
+		} else {
+			compiler.emit(reassign.Loc(), OpReassign, compiler.name(target.Value), int32(btoi(reassign.Append)))
+		}
+
+		break
+	default:
+		compiler.fail(diag.SyntaxError, reassign.Loc(), "Cannot assign to this expression")
+		break
+	}
 }
 
 func (compiler *Compiler) VisitCall(call *ast.Call) {
-	compiler.add(&MakeCall{
-		Args: len(call.Args),
-		Location: call.Loc(),
-	})
+	compiler.emit(call.Loc(), OpMakeCall, int32(len(call.Args)))
 }
 
 func (compiler *Compiler) VisitMember(member *ast.Member) {
-	compiler.add(&LoadMember{
-		Location: member.Loc(),
-	})
+	compiler.emit(member.Loc(), OpLoadMember, compiler.name(member.Field.(*ast.Ident).Value), int32(btoi(member.Optional)))
+}
+
+func (compiler *Compiler) VisitBinary(binary *ast.Binary) {
+	switch binary.Op {
+	case ast.Coalesce:
+		compiler.emit(binary.Loc(), OpCoalesce)
+		break
+	case ast.Equal:
+		compiler.emit(binary.Loc(), OpCompare, int32(EqualOp))
+		break
+	case ast.NotEqual:
+		compiler.emit(binary.Loc(), OpCompare, int32(NotEqualOp))
+		break
+	}
+}
+
+func (compiler *Compiler) VisitNot(not *ast.Not) {
+	compiler.emit(not.Loc(), OpNot)
+}
+
+func (compiler *Compiler) VisitPreConditional(conditional *ast.Conditional) {
+	compiler.emitJump(conditional.Loc(), OpJumpIfFalse, -1)
+}
+
+func (compiler *Compiler) VisitConditionalElse(conditional *ast.Conditional) {
+	compiler.jumpElse(conditional.Loc())
+}
+
+func (compiler *Compiler) VisitConditional(conditional *ast.Conditional) {
+	compiler.patchJump()
+}
+
+func (compiler *Compiler) VisitPreIf(if_ *ast.If) {
+	compiler.emitJump(if_.Loc(), OpJumpIfFalse, -1)
+	compiler.emit(if_.Then.Loc(), OpPushFrame, compiler.openScope(nil))
+}
+
+func (compiler *Compiler) VisitIfElse(if_ *ast.If) {
+	compiler.closeScope()
+	compiler.emit(if_.Loc(), OpPopFrame)
+
+	if if_.Else == nil {
+		compiler.patchJump()
+		return
+	}
+
+	compiler.jumpElse(if_.Loc())
+	compiler.emit(if_.Else.Loc(), OpPushFrame, compiler.openScope(nil))
+}
+
+func (compiler *Compiler) VisitIf(if_ *ast.If) {
+	if if_.Else == nil {
+		return
+	}
+
+	compiler.closeScope()
+	compiler.emit(if_.Loc(), OpPopFrame)
+	compiler.patchJump()
+}
+
+func (compiler *Compiler) VisitPreFor(for_ *ast.For) {
+	compiler.openLoop(for_.Key, for_.Value, for_.Loc())
+}
+
+func (compiler *Compiler) VisitFor(for_ *ast.For) {
+	compiler.closeLoop(for_.Loc())
+}
+
+func (compiler *Compiler) VisitPreArray(array *ast.Array) {
+	compiler.emit(array.Loc(), OpNewArray)
+}
+
+func (compiler *Compiler) VisitArrayElement(elem ast.Expr) {
+	compiler.emit(elem.Loc(), OpAppendArray, 0)
+}
+
+func (compiler *Compiler) VisitArray(array *ast.Array) {
+
+}
+
+func (compiler *Compiler) VisitPreComprehension(comprehension *ast.Comprehension) {
+	compiler.emit(comprehension.Loc(), OpNewArray)
+}
+
+func (compiler *Compiler) VisitComprehensionLoop(comprehension *ast.Comprehension) {
+	compiler.openLoop(comprehension.Key, comprehension.Value, comprehension.Loc())
+}
+
+func (compiler *Compiler) VisitComprehensionFilter(comprehension *ast.Comprehension) {
+	compiler.emitJump(comprehension.Filter.Loc(), OpJumpIfFalse, -1)
+}
+
+func (compiler *Compiler) VisitComprehension(comprehension *ast.Comprehension) {
+	compiler.emit(comprehension.Result.Loc(), OpAppendArray, 1)
+
+	if comprehension.Filter != nil {
+		compiler.patchJump()
+	}
+
+	compiler.closeLoop(comprehension.Loc())
+}
+
+func (compiler *Compiler) pattern(node ast.Pattern) *Pattern {
+	switch pattern := node.(type) {
+	case *ast.LiteralPattern:
+		return &Pattern{
+			Kind: LiteralPattern,
+			Type: compiler.literalType(pattern.Literal),
+			Value: pattern.Literal.Value,
+		}
+	case *ast.TypePattern:
+		typePattern := &Pattern{
+			Kind: TypePattern,
+			Type: compiler.typeId(pattern.Type.Name.Value),
+			TypeName: pattern.Type.Name.Value,
+			Array: pattern.Type.Array,
+			Optional: pattern.Type.Optional,
+			Fields: []FieldPattern{},
+		}
+
+		for _, field := range pattern.Fields {
+			fieldPattern := FieldPattern{Name: field.Name.Value, Slot: -1}
+
+			if field.Pattern != nil {
+				fieldPattern.Pattern = compiler.pattern(field.Pattern)
+			} else {
+				fieldPattern.Slot = compiler.declare(field.Name.Value)
+			}
+
+			typePattern.Fields = append(typePattern.Fields, fieldPattern)
+		}
+
+		return typePattern
+	}
+
+	return &Pattern{Kind: WildcardPattern}
+}
+
+// isExhaustive only accepts a wildcard arm or both boolean literals, type
+// patterns don't count as the compiler doesn't know the type of the value
+func (compiler *Compiler) isExhaustive(match *ast.Match) bool {
+	literals := map[interface{}]bool{}
+
+	for _, arm := range match.Arms {
+		switch pattern := arm.Pattern.(type) {
+		case *ast.WildcardPattern:
+			return true
+		case *ast.LiteralPattern:
+			if pattern.Literal.Type == ast.Boolean {
+				literals[pattern.Literal.Value] = true
+			}
+
+			break
+		}
+	}
+
+	return literals[true] && literals[false]
+}
+
+func (compiler *Compiler) VisitPreMatch(match *ast.Match) {
+	if !compiler.isExhaustive(match) {
+		compiler.fail(diag.NonExhaustiveMatch, match.Loc(), "Non-exhaustive match").
+			WithNote("add a `_` arm to handle the remaining values")
+	}
+}
+
+func (compiler *Compiler) VisitPreMatchArm(arm *ast.MatchArm) {
+	compiler.emit(arm.Location, OpPushFrame, compiler.openScope(nil))
+	compiler.emit(arm.Location, OpMatchPattern, compiler.constant(compiler.pattern(arm.Pattern)))
+	compiler.emitJump(arm.Location, OpJumpIfFalse, -1)
+	compiler.emit(arm.Location, OpPop)
+}
+
+func (compiler *Compiler) VisitMatchArm(arm *ast.MatchArm) {
+	compiler.closeScope()
+	compiler.emit(arm.Location, OpPopFrame)
+	compiler.jumpElse(arm.Location)
+	compiler.emit(arm.Location, OpPopFrame)
+}
+
+func (compiler *Compiler) VisitMatch(match *ast.Match) {
+	compiler.emit(match.Loc(), OpMatchFail)
+
+	for range match.Arms {
+		compiler.patchJump()
+	}
+}
+
+func (compiler *Compiler) VisitIsNull(isNull *ast.IsNull) {
+	compiler.emit(isNull.Loc(), OpIsNull)
 }
 
 func (compiler *Compiler) VisitExprStmt(exprStmt *ast.ExprStmt) {
-	// @TODO: Discard
+	compiler.emit(exprStmt.Loc(), OpPop)
+}
+
+func (compiler *Compiler) VisitAssert(assert *ast.Assert) {
+	compiler.emit(assert.Loc(), OpAssert)
 }
 
 func (compiler *Compiler) VisitInlineExpr(expr ast.Expr) {
-	switch expr.(type) {
+	switch ident := expr.(type) {
 	case *ast.Ident:
-		compiler.add(&LoadVal{
-			Location: expr.Loc(),
-		})
+		if depth, slot, found := compiler.resolve(ident.Value); found {
+			compiler.emit(ident.Loc(), OpLoadSlot, depth, slot)
+		} else {
+			compiler.emit(ident.Loc(), OpLoadVal, compiler.name(ident.Value))
+		}
+
 		break
 	}
 }
@@ -170,7 +615,21 @@ func (compiler *Compiler) VisitSource(source *ast.Source) {
 
 }
 
-func (compiler *Compiler) Compile() ([]Instruction, error) {
+func (compiler *Compiler) Compile() (*Program, error) {
+	if compiler.Optimize {
+		optimize(compiler.source)
+	}
+
+	compiler.openScope(nil)
 	compiler.source.Accept(compiler)
-	return compiler.instructions, nil
+
+	if compiler.err != nil {
+		return nil, compiler.err
+	}
+
+	if compiler.Optimize {
+		optimizeCode(compiler.program)
+	}
+
+	return compiler.program, nil
 }
@@ -0,0 +1,184 @@
+package compiler
+
+import (
+	"io"
+	"fmt"
+	"strings"
+	"strconv"
+	"dmeijboom/config/tokens"
+)
+
+type Disassembler struct {
+	Sources map[string]string
+	program *Program
+	lines map[string][]string
+}
+
+func NewDisassembler(program *Program) *Disassembler {
+	return &Disassembler{
+		Sources: map[string]string{},
+		program: program,
+		lines: map[string][]string{},
+	}
+}
+
+func (disassembler *Disassembler) sourceLine(loc *tokens.Location) (string, bool) {
+	lines, exist := disassembler.lines[loc.File]
+
+	if !exist {
+		source, hasSource := disassembler.Sources[loc.File]
+
+		if hasSource {
+			lines = strings.Split(source, "\n")
+		}
+
+		disassembler.lines[loc.File] = lines
+	}
+
+	if loc.Line < 1 || loc.Line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimSpace(lines[loc.Line-1]), true
+}
+
+func (disassembler *Disassembler) constant(value interface{}) string {
+	switch constant := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(constant)
+	case []Annotation:
+		annotations := []string{}
+
+		for _, annotation := range constant {
+			args := []string{}
+
+			for _, arg := range annotation.Args {
+				args = append(args, disassembler.constant(arg))
+			}
+
+			annotations = append(annotations, fmt.Sprintf("@%s(%s)", annotation.Name, strings.Join(args, ", ")))
+		}
+
+		return strings.Join(annotations, " ")
+	case *Pattern:
+		return disassembler.pattern(constant)
+	}
+
+	return fmt.Sprint(value)
+}
+
+func (disassembler *Disassembler) pattern(pattern *Pattern) string {
+	switch pattern.Kind {
+	case LiteralPattern:
+		return disassembler.constant(pattern.Value)
+	case TypePattern:
+		fields := []string{}
+
+		for _, field := range pattern.Fields {
+			if field.Pattern == nil {
+				fields = append(fields, field.Name)
+			} else {
+				fields = append(fields, field.Name + " = " + disassembler.pattern(field.Pattern))
+			}
+		}
+
+		return fmt.Sprintf("%s { %s }", pattern.TypeName, strings.Join(fields, ", "))
+	}
+
+	return "_"
+}
+
+func (disassembler *Disassembler) operands(op Opcode, operands []int32, frames *frameChain) string {
+	program := disassembler.program
+	formatted := []string{}
+	depth := int32(0)
+
+	if op == OpCloseSection {
+		depth = 1
+	}
+
+	for i, kind := range op.Info().Operands {
+		index := operands[i]
+		operand := strconv.Itoa(int(index))
+
+		switch kind {
+		case ConstOperand:
+			if index >= 0 && int(index) < len(program.Constants) {
+				operand += " (" + disassembler.constant(program.Constants[index]) + ")"
+			}
+
+			break
+		case NameOperand:
+			if index >= 0 && int(index) < len(program.Names) {
+				operand += " (" + program.Names[index] + ")"
+			}
+
+			break
+		case ScopeOperand:
+			if index >= 0 && int(index) < len(program.Scopes) {
+				operand += " {" + strings.Join(program.Scopes[index].Names, ", ") + "}"
+			}
+
+			break
+		case DepthOperand:
+			depth = index
+			break
+		case SlotOperand:
+			frame := frames.ancestor(depth)
+
+			if frame != nil && frame.scope >= 0 && index >= 0 && int(index) < len(program.Scopes[frame.scope].Names) {
+				operand += " (" + program.Scopes[frame.scope].Names[index] + ")"
+			}
+
+			break
+		case TargetOperand:
+			operand = "-> " + operand
+			break
+		}
+
+		formatted = append(formatted, operand)
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+// Disassemble prints every instruction with its offset, the stack depth
+// before it runs and its resolved operands, preceded by the source line it
+// was compiled from. It returns the verification error of invalid programs
+// after printing what it could decode.
+func (disassembler *Disassembler) Disassemble(w io.Writer) error {
+	program := disassembler.program
+	verifier, err := analyze(program)
+	var previous *tokens.Location
+
+	for offset := 0; offset < len(program.Code); {
+		op, operands, ok := program.Decode(offset)
+
+		if !ok {
+			fmt.Fprintf(w, "%6d        <invalid %d>\n", offset, program.Code[offset])
+			break
+		}
+
+		if loc := program.Loc(offset); loc != nil && (previous == nil || loc.Line != previous.Line || loc.File != previous.File) {
+			if line, found := disassembler.sourceLine(loc); found {
+				fmt.Fprintf(w, "%s:%d | %s\n", loc.File, loc.Line, line)
+			}
+
+			previous = loc
+		}
+
+		depth := "?"
+
+		if verifier.depths[offset] > 0 {
+			depth = strconv.Itoa(verifier.depths[offset] - 1)
+		}
+
+		line := fmt.Sprintf("%6d  [%s]  %-13s %s", offset, depth, op, disassembler.operands(op, operands, verifier.frames[offset]))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		offset += op.Width()
+	}
+
+	return err
+}
@@ -0,0 +1,405 @@
+package compiler
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"bytes"
+	"math"
+	"encoding/binary"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
+)
+
+// FormatVersion is bumped whenever the opcodes or the encoding change, so
+// precompiled programs from another version are rejected instead of misread
+const FormatVersion = 1
+
+var Magic = []byte("CFC\x00")
+
+const (
+	nullTag byte = iota
+	intTag
+	floatTag
+	stringTag
+	boolTag
+	annotationsTag
+	patternTag
+)
+
+type encoder struct {
+	w *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (encoder *encoder) uvarint(value uint64) {
+	n := binary.PutUvarint(encoder.buf[:], value)
+	encoder.w.Write(encoder.buf[:n])
+}
+
+func (encoder *encoder) varint(value int64) {
+	n := binary.PutVarint(encoder.buf[:], value)
+	encoder.w.Write(encoder.buf[:n])
+}
+
+func (encoder *encoder) string(value string) {
+	encoder.uvarint(uint64(len(value)))
+	encoder.w.WriteString(value)
+}
+
+func (encoder *encoder) bool(value bool) {
+	encoder.w.WriteByte(byte(btoi(value)))
+}
+
+func (encoder *encoder) literal(value interface{}) error {
+	switch literal := value.(type) {
+	case nil:
+		encoder.w.WriteByte(nullTag)
+		break
+	case int:
+		encoder.w.WriteByte(intTag)
+		encoder.varint(int64(literal))
+		break
+	case float64:
+		encoder.w.WriteByte(floatTag)
+		encoder.uvarint(math.Float64bits(literal))
+		break
+	case string:
+		encoder.w.WriteByte(stringTag)
+		encoder.string(literal)
+		break
+	case bool:
+		encoder.w.WriteByte(boolTag)
+		encoder.bool(literal)
+		break
+	default:
+		return diag.New(diag.InternalError, diag.Span{}, "Cannot encode constant of type %T", value)
+	}
+
+	return nil
+}
+
+func (encoder *encoder) constant(value interface{}) error {
+	switch constant := value.(type) {
+	case []Annotation:
+		encoder.w.WriteByte(annotationsTag)
+		encoder.uvarint(uint64(len(constant)))
+
+		for _, annotation := range constant {
+			encoder.string(annotation.Name)
+			encoder.uvarint(uint64(len(annotation.Args)))
+
+			for _, arg := range annotation.Args {
+				if err := encoder.literal(arg); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	case *Pattern:
+		encoder.w.WriteByte(patternTag)
+		return encoder.pattern(constant)
+	}
+
+	return encoder.literal(value)
+}
+
+func (encoder *encoder) pattern(pattern *Pattern) error {
+	encoder.uvarint(uint64(pattern.Kind))
+	encoder.varint(int64(pattern.Type))
+	encoder.string(pattern.TypeName)
+	encoder.bool(pattern.Array)
+	encoder.bool(pattern.Optional)
+
+	if err := encoder.literal(pattern.Value); err != nil {
+		return err
+	}
+
+	encoder.uvarint(uint64(len(pattern.Fields)))
+
+	for _, field := range pattern.Fields {
+		encoder.string(field.Name)
+		encoder.varint(int64(field.Slot))
+		encoder.bool(field.Pattern != nil)
+
+		if field.Pattern == nil {
+			continue
+		}
+
+		if err := encoder.pattern(field.Pattern); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode writes the program in the binary `.cfc` format: the magic header,
+// format version and source hash followed by the name table, constant pool,
+// scopes, instructions and line table
+func Encode(w io.Writer, program *Program) error {
+	encoder := &encoder{w: bufio.NewWriter(w)}
+
+	encoder.w.Write(Magic)
+	binary.Write(encoder.w, binary.LittleEndian, uint16(FormatVersion))
+	encoder.w.Write(program.SourceHash[:])
+
+	encoder.uvarint(uint64(len(program.Names)))
+
+	for _, name := range program.Names {
+		encoder.string(name)
+	}
+
+	encoder.uvarint(uint64(len(program.Constants)))
+
+	for _, constant := range program.Constants {
+		if err := encoder.constant(constant); err != nil {
+			return err
+		}
+	}
+
+	encoder.uvarint(uint64(len(program.Scopes)))
+
+	for _, scope := range program.Scopes {
+		encoder.uvarint(uint64(len(scope.Names)))
+
+		for _, name := range scope.Names {
+			encoder.string(name)
+		}
+	}
+
+	encoder.uvarint(uint64(len(program.Code)))
+
+	for _, word := range program.Code {
+		encoder.varint(int64(word))
+	}
+
+	encoder.uvarint(uint64(len(program.Lines)))
+
+	for _, line := range program.Lines {
+		encoder.varint(int64(line.Offset))
+		encoder.uvarint(uint64(line.Location.Line))
+		encoder.uvarint(uint64(line.Location.Column))
+		encoder.string(line.Location.File)
+	}
+
+	return encoder.w.Flush()
+}
+
+
+type decoder struct {
+	r *bufio.Reader
+	err error
+}
+
+func (decoder *decoder) fail(err error) {
+	if decoder.err == nil {
+		decoder.err = diag.New(diag.InvalidBytecode, diag.Span{}, "Invalid bytecode: %s", err.Error())
+	}
+}
+
+func (decoder *decoder) uvarint() uint64 {
+	value, err := binary.ReadUvarint(decoder.r)
+
+	if err != nil {
+		decoder.fail(err)
+	}
+
+	return value
+}
+
+func (decoder *decoder) varint() int64 {
+	value, err := binary.ReadVarint(decoder.r)
+
+	if err != nil {
+		decoder.fail(err)
+	}
+
+	return value
+}
+
+func (decoder *decoder) int32() int32 {
+	value := decoder.varint()
+
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		decoder.fail(io.ErrUnexpectedEOF)
+	}
+
+	return int32(value)
+}
+
+// count reads a length prefix, which stops the decoder at the first error
+// so a corrupt length can't keep it looping
+func (decoder *decoder) count() int {
+	value := decoder.uvarint()
+
+	if decoder.err != nil || value > math.MaxInt32 {
+		decoder.fail(io.ErrUnexpectedEOF)
+		return 0
+	}
+
+	return int(value)
+}
+
+func (decoder *decoder) byte() byte {
+	value, err := decoder.r.ReadByte()
+
+	if err != nil {
+		decoder.fail(err)
+	}
+
+	return value
+}
+
+func (decoder *decoder) string() string {
+	var builder bytes.Buffer
+
+	if _, err := io.CopyN(&builder, decoder.r, int64(decoder.count())); err != nil {
+		decoder.fail(err)
+	}
+
+	return builder.String()
+}
+
+func (decoder *decoder) bool() bool {
+	return decoder.byte() != 0
+}
+
+func (decoder *decoder) literal(tag byte) interface{} {
+	switch tag {
+	case nullTag:
+		return nil
+	case intTag:
+		return int(decoder.varint())
+	case floatTag:
+		return math.Float64frombits(decoder.uvarint())
+	case stringTag:
+		return decoder.string()
+	case boolTag:
+		return decoder.bool()
+	}
+
+	decoder.fail(fmt.Errorf("unknown constant tag %d", tag))
+	return nil
+}
+
+func (decoder *decoder) constant() interface{} {
+	switch tag := decoder.byte(); tag {
+	case annotationsTag:
+		annotations := []Annotation{}
+
+		for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+			annotation := Annotation{Name: decoder.string(), Args: []interface{}{}}
+
+			for j, args := 0, decoder.count(); j < args && decoder.err == nil; j++ {
+				annotation.Args = append(annotation.Args, decoder.literal(decoder.byte()))
+			}
+
+			annotations = append(annotations, annotation)
+		}
+
+		return annotations
+	case patternTag:
+		return decoder.pattern()
+	default:
+		return decoder.literal(tag)
+	}
+}
+
+func (decoder *decoder) pattern() *Pattern {
+	pattern := &Pattern{
+		Kind: PatternKind(decoder.uvarint()),
+		Type: TypeId(decoder.varint()),
+		TypeName: decoder.string(),
+		Array: decoder.bool(),
+		Optional: decoder.bool(),
+		Value: decoder.literal(decoder.byte()),
+		Fields: []FieldPattern{},
+	}
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		field := FieldPattern{Name: decoder.string(), Slot: decoder.int32()}
+
+		if decoder.bool() {
+			field.Pattern = decoder.pattern()
+		}
+
+		pattern.Fields = append(pattern.Fields, field)
+	}
+
+	return pattern
+}
+
+func (decoder *decoder) strings() []string {
+	values := []string{}
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		values = append(values, decoder.string())
+	}
+
+	return values
+}
+
+// Decode reads a program written by Encode and rejects programs that were
+// encoded with another format version or that don't end after the line table
+func Decode(r io.Reader) (*Program, error) {
+	decoder := &decoder{r: bufio.NewReader(r)}
+	header := make([]byte, len(Magic))
+	var version uint16
+
+	if _, err := io.ReadFull(decoder.r, header); err != nil || !bytes.Equal(header, Magic) {
+		return nil, diag.New(diag.InvalidBytecode, diag.Span{}, "Invalid bytecode: missing `.cfc` header")
+	} else if err := binary.Read(decoder.r, binary.LittleEndian, &version); err != nil {
+		return nil, diag.New(diag.InvalidBytecode, diag.Span{}, "Invalid bytecode: %s", err.Error())
+	} else if version != FormatVersion {
+		return nil, diag.New(diag.InvalidBytecode, diag.Span{}, "Unsupported bytecode version %d, expected version %d", version, FormatVersion).
+			WithNote("recompile the program with this version")
+	}
+
+	program := &Program{
+		Code: []int32{},
+		Constants: []interface{}{},
+		Scopes: []Scope{},
+		Lines: []Line{},
+	}
+
+	if _, err := io.ReadFull(decoder.r, program.SourceHash[:]); err != nil {
+		decoder.fail(err)
+	}
+
+	program.Names = decoder.strings()
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		program.Constants = append(program.Constants, decoder.constant())
+	}
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		program.Scopes = append(program.Scopes, Scope{Names: decoder.strings()})
+	}
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		program.Code = append(program.Code, decoder.int32())
+	}
+
+	for i, count := 0, decoder.count(); i < count && decoder.err == nil; i++ {
+		line := Line{Offset: decoder.int32()}
+		line.Location = tokens.Location{
+			Line: int(decoder.uvarint()),
+			Column: int(decoder.uvarint()),
+			File: decoder.string(),
+		}
+
+		program.Lines = append(program.Lines, line)
+	}
+
+	if _, err := decoder.r.ReadByte(); err != io.EOF {
+		decoder.fail(fmt.Errorf("trailing data after the line table"))
+	}
+
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+
+	return program, nil
+}
@@ -0,0 +1,661 @@
+package compiler
+
+import (
+	"reflect"
+	"dmeijboom/config/ast"
+)
+
+// optimizer rewrites the AST before it's compiled: it folds constant
+// expressions, removes branches that can't be taken and drops typedefs and
+// block bindings nobody refers to
+type optimizer struct {
+	values map[string]int
+	types map[string]int
+}
+
+func optimize(source *ast.Source) {
+	optimizer := &optimizer{}
+	optimizer.block(source.Block)
+
+	optimizer.values = map[string]int{}
+	optimizer.types = map[string]int{}
+	optimizer.countBlock(source.Block)
+
+	// Dropping a typedef can make the types it refers to unused as well
+	for optimizer.prune(source.Block, false) {
+	}
+}
+
+func isLiteral(expr ast.Expr, literalType ast.LiteralType) bool {
+	literal, isLiteral := expr.(*ast.Literal)
+	return isLiteral && literal.Type == literalType
+}
+
+// isPure reports whether binding the value can't fail at runtime, which
+// rules out arrays that mix element types
+func isPure(expr ast.Expr) bool {
+	switch node := expr.(type) {
+	case *ast.Literal:
+		return true
+	case *ast.Array:
+		for _, elem := range node.Elements {
+			literal, isElemLiteral := elem.(*ast.Literal)
+
+			if !isElemLiteral || !isLiteral(node.Elements[0], literal.Type) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (optimizer *optimizer) block(block *ast.Block) {
+	body := []ast.Node{}
+
+	for _, node := range block.Body {
+		if if_, isIf := node.(*ast.If); isIf {
+			if_.Cond = optimizer.expr(if_.Cond)
+
+			// The bytecode pass removes the other constant branches, this one doesn't need a frame at all
+			if isLiteral(if_.Cond, ast.Boolean) && !if_.Cond.(*ast.Literal).Value.(bool) && if_.Else == nil {
+				continue
+			}
+		}
+
+		optimizer.stmt(node)
+		body = append(body, node)
+	}
+
+	block.Body = body
+}
+
+func (optimizer *optimizer) stmt(node ast.Node) {
+	switch stmt := node.(type) {
+	case *ast.Block:
+		optimizer.block(stmt)
+		break
+	case *ast.Section:
+		optimizer.block(stmt.Block)
+		break
+	case *ast.Assign:
+		if stmt.Value != nil {
+			stmt.Value = optimizer.expr(stmt.Value)
+		}
+
+		break
+	case *ast.Reassign:
+		stmt.Value = optimizer.expr(stmt.Value)
+		stmt.Target = optimizer.expr(stmt.Target)
+		break
+	case *ast.Assert:
+		stmt.Cond = optimizer.expr(stmt.Cond)
+		stmt.Message = optimizer.expr(stmt.Message)
+		break
+	case *ast.If:
+		optimizer.block(stmt.Then)
+
+		if stmt.Else != nil {
+			optimizer.block(stmt.Else)
+		}
+
+		break
+	case *ast.For:
+		stmt.Collection = optimizer.expr(stmt.Collection)
+		optimizer.block(stmt.Block)
+		break
+	case *ast.ExprStmt:
+		stmt.Expr = optimizer.expr(stmt.Expr)
+		break
+	}
+}
+
+func (optimizer *optimizer) expr(expr ast.Expr) ast.Expr {
+	switch node := expr.(type) {
+	case *ast.Member:
+		node.Object = optimizer.expr(node.Object)
+		break
+	case *ast.Call:
+		for i := range node.Args {
+			node.Args[i] = optimizer.expr(node.Args[i])
+		}
+
+		node.Callee = optimizer.expr(node.Callee)
+		break
+	case *ast.Initialize:
+		for i := range node.Spreads {
+			node.Spreads[i].Value = optimizer.expr(node.Spreads[i].Value)
+		}
+
+		for i := range node.Fields {
+			node.Fields[i].Value = optimizer.expr(node.Fields[i].Value)
+		}
+
+		break
+	case *ast.Array:
+		for i := range node.Elements {
+			node.Elements[i] = optimizer.expr(node.Elements[i])
+		}
+
+		break
+	case *ast.Comprehension:
+		node.Collection = optimizer.expr(node.Collection)
+		node.Result = optimizer.expr(node.Result)
+
+		if node.Filter != nil {
+			node.Filter = optimizer.expr(node.Filter)
+		}
+
+		break
+	case *ast.Match:
+		node.Value = optimizer.expr(node.Value)
+
+		for i := range node.Arms {
+			node.Arms[i].Result = optimizer.expr(node.Arms[i].Result)
+		}
+
+		break
+	case *ast.Binary:
+		return optimizer.binary(node)
+	case *ast.Not:
+		node.Value = optimizer.expr(node.Value)
+
+		if isLiteral(node.Value, ast.Boolean) {
+			return &ast.Literal{Type: ast.Boolean, Value: !node.Value.(*ast.Literal).Value.(bool), Location: node.Location}
+		}
+
+		break
+	case *ast.IsNull:
+		node.Value = optimizer.expr(node.Value)
+
+		if literal, isLiteral := node.Value.(*ast.Literal); isLiteral {
+			return &ast.Literal{Type: ast.Boolean, Value: literal.Type == ast.Null, Location: node.Location}
+		}
+
+		break
+	case *ast.Conditional:
+		node.Cond = optimizer.expr(node.Cond)
+		node.Then = optimizer.expr(node.Then)
+		node.Else = optimizer.expr(node.Else)
+
+		if isLiteral(node.Cond, ast.Boolean) {
+			if node.Cond.(*ast.Literal).Value.(bool) {
+				return node.Then
+			}
+
+			return node.Else
+		}
+
+		break
+	}
+
+	return expr
+}
+
+func (optimizer *optimizer) binary(binary *ast.Binary) ast.Expr {
+	binary.Left = optimizer.expr(binary.Left)
+	binary.Right = optimizer.expr(binary.Right)
+	left, isLeftLiteral := binary.Left.(*ast.Literal)
+	right, isRightLiteral := binary.Right.(*ast.Literal)
+
+	switch binary.Op {
+	case ast.Coalesce:
+		// Both sides are always evaluated, so the fallback can only be dropped when it's a literal
+		if isLeftLiteral && left.Type == ast.Null {
+			return binary.Right
+		} else if isLeftLiteral && isRightLiteral {
+			return binary.Left
+		}
+
+		break
+	case ast.Equal, ast.NotEqual:
+		if !isLeftLiteral || !isRightLiteral {
+			break
+		}
+
+		equal := left.Type == right.Type && left.Value == right.Value
+
+		if left.Type == ast.Null || right.Type == ast.Null {
+			equal = left.Type == right.Type
+		}
+
+		return &ast.Literal{Type: ast.Boolean, Value: equal == (binary.Op == ast.Equal), Location: binary.Location}
+	}
+
+	return binary
+}
+
+func (optimizer *optimizer) countBlock(block *ast.Block) {
+	for _, node := range block.Body {
+		optimizer.count(node)
+	}
+}
+
+func (optimizer *optimizer) countType(node *ast.Type) {
+	if node == nil {
+		return
+	}
+
+	optimizer.types[node.Name.Value]++
+	optimizer.countType(node.Base)
+
+	for _, field := range node.Fields {
+		optimizer.countType(field.Type)
+	}
+}
+
+func (optimizer *optimizer) count(node ast.Node) {
+	switch stmt := node.(type) {
+	case *ast.Block:
+		optimizer.countBlock(stmt)
+		break
+	case *ast.Section:
+		optimizer.countType(stmt.Type)
+		optimizer.countBlock(stmt.Block)
+		break
+	case *ast.Typedef:
+		optimizer.countType(stmt.Type)
+		break
+	case *ast.Assign:
+		optimizer.countType(stmt.Type)
+
+		if stmt.Value != nil {
+			optimizer.countExpr(stmt.Value)
+		}
+
+		break
+	case *ast.Reassign:
+		optimizer.countExpr(stmt.Value)
+		optimizer.countExpr(stmt.Target)
+		break
+	case *ast.Assert:
+		optimizer.countExpr(stmt.Cond)
+		optimizer.countExpr(stmt.Message)
+		break
+	case *ast.If:
+		optimizer.countExpr(stmt.Cond)
+		optimizer.countBlock(stmt.Then)
+
+		if stmt.Else != nil {
+			optimizer.countBlock(stmt.Else)
+		}
+
+		break
+	case *ast.For:
+		optimizer.countExpr(stmt.Collection)
+		optimizer.countBlock(stmt.Block)
+		break
+	case *ast.ExprStmt:
+		optimizer.countExpr(stmt.Expr)
+		break
+	}
+}
+
+func (optimizer *optimizer) countExpr(expr ast.Expr) {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		optimizer.values[node.Value]++
+		break
+	case *ast.Member:
+		optimizer.countExpr(node.Object)
+		break
+	case *ast.Call:
+		for _, arg := range node.Args {
+			optimizer.countExpr(arg)
+		}
+
+		optimizer.countExpr(node.Callee)
+		break
+	case *ast.Initialize:
+		optimizer.countType(node.Type)
+
+		for _, spread := range node.Spreads {
+			optimizer.countExpr(spread.Value)
+		}
+
+		for _, field := range node.Fields {
+			optimizer.countExpr(field.Value)
+		}
+
+		break
+	case *ast.Binary:
+		optimizer.countExpr(node.Left)
+		optimizer.countExpr(node.Right)
+		break
+	case *ast.IsNull:
+		optimizer.countExpr(node.Value)
+		break
+	case *ast.Not:
+		optimizer.countExpr(node.Value)
+		break
+	case *ast.Conditional:
+		optimizer.countExpr(node.Cond)
+		optimizer.countExpr(node.Then)
+		optimizer.countExpr(node.Else)
+		break
+	case *ast.Array:
+		for _, elem := range node.Elements {
+			optimizer.countExpr(elem)
+		}
+
+		break
+	case *ast.Comprehension:
+		optimizer.countExpr(node.Collection)
+		optimizer.countExpr(node.Result)
+
+		if node.Filter != nil {
+			optimizer.countExpr(node.Filter)
+		}
+
+		break
+	case *ast.Match:
+		optimizer.countExpr(node.Value)
+
+		for _, arm := range node.Arms {
+			optimizer.countPattern(arm.Pattern)
+			optimizer.countExpr(arm.Result)
+		}
+
+		break
+	}
+}
+
+func (optimizer *optimizer) countPattern(node ast.Pattern) {
+	if pattern, isTypePattern := node.(*ast.TypePattern); isTypePattern {
+		optimizer.countType(pattern.Type)
+
+		for _, field := range pattern.Fields {
+			if field.Pattern != nil {
+				optimizer.countPattern(field.Pattern)
+			}
+		}
+	}
+}
+
+func (optimizer *optimizer) uncountType(node *ast.Type) {
+	if node == nil {
+		return
+	}
+
+	optimizer.types[node.Name.Value]--
+	optimizer.uncountType(node.Base)
+
+	for _, field := range node.Fields {
+		optimizer.uncountType(field.Type)
+	}
+}
+
+// prune drops typedefs that are never referenced and, outside of the root
+// and sections (whose bindings are exported), pure bindings that are never read
+func (optimizer *optimizer) prune(block *ast.Block, local bool) bool {
+	changed := false
+	body := []ast.Node{}
+
+	for _, node := range block.Body {
+		switch stmt := node.(type) {
+		case *ast.Typedef:
+			// A typedef referring to itself doesn't keep it alive, extending
+			// another type is checked at runtime so those are always kept
+			self := 0
+			optimizer.countSelf(stmt.Type, stmt.Name.Value, &self)
+
+			if stmt.Type.Base == nil && optimizer.types[stmt.Name.Value] - self == 0 {
+				optimizer.uncountType(stmt.Type)
+				changed = true
+				continue
+			}
+
+			break
+		case *ast.Assign:
+			if local && optimizer.values[stmt.Name.Value] == 0 && stmt.Type == nil && len(stmt.Annotations) == 0 && stmt.Value != nil && isPure(stmt.Value) {
+				changed = true
+				continue
+			}
+
+			break
+		case *ast.Block:
+			changed = optimizer.prune(stmt, local) || changed
+			break
+		case *ast.Section:
+			changed = optimizer.prune(stmt.Block, false) || changed
+			break
+		case *ast.If:
+			changed = optimizer.prune(stmt.Then, true) || changed
+
+			if stmt.Else != nil {
+				changed = optimizer.prune(stmt.Else, true) || changed
+			}
+
+			break
+		case *ast.For:
+			changed = optimizer.prune(stmt.Block, true) || changed
+			break
+		}
+
+		body = append(body, node)
+	}
+
+	block.Body = body
+	return changed
+}
+
+func (optimizer *optimizer) countSelf(node *ast.Type, name string, count *int) {
+	if node == nil {
+		return
+	}
+
+	if node.Name.Value == name {
+		*count++
+	}
+
+	optimizer.countSelf(node.Base, name, count)
+
+	for _, field := range node.Fields {
+		optimizer.countSelf(field.Type, name, count)
+	}
+}
+
+// successors returns the offsets control can continue at after an instruction
+func successors(offset int, op Opcode, operands []int32) []int {
+	next := offset + op.Width()
+
+	switch op {
+	case OpJump:
+		return []int{int(operands[0])}
+	case OpJumpIfFalse, OpForIter:
+		return []int{int(operands[0]), next}
+	case OpMatchFail:
+		return nil
+	}
+
+	return []int{next}
+}
+
+func jumpTargets(program *Program) map[int]bool {
+	targets := map[int]bool{}
+
+	for offset := 0; offset < len(program.Code); {
+		op, operands, _ := program.Decode(offset)
+
+		for i, kind := range op.Info().Operands {
+			if kind == TargetOperand {
+				targets[int(operands[i])] = true
+			}
+		}
+
+		offset += op.Width()
+	}
+
+	return targets
+}
+
+// optimizeCode folds jumps on constant conditions, removes instructions that
+// can't be reached or don't do anything and compacts the constant pool
+func optimizeCode(program *Program) {
+	for changed := true; changed; {
+		changed = foldJumps(program)
+		changed = removeDeadCode(program) || changed
+	}
+
+	compactConstants(program)
+}
+
+func foldJumps(program *Program) bool {
+	targets := jumpTargets(program)
+	changed := false
+
+	for offset := 0; offset < len(program.Code); {
+		op, operands, _ := program.Decode(offset)
+		next := offset + op.Width()
+
+		if op == OpLoadConst && !targets[next] {
+			condition, isBool := program.Constants[operands[0]].(bool)
+
+			if jumpOp, jumpOperands, ok := program.Decode(next); isBool && ok && jumpOp == OpJumpIfFalse {
+				// A true condition never jumps, which makes the jump itself dead
+				if condition {
+					program.Code[offset] = int32(OpJump)
+					program.Code[offset + 1] = int32(next + jumpOp.Width())
+				} else {
+					program.Code[offset] = int32(OpJump)
+					program.Code[offset + 1] = jumpOperands[0]
+				}
+
+				changed = true
+			}
+		}
+
+		offset = next
+	}
+
+	return changed
+}
+
+func removeDeadCode(program *Program) bool {
+	reachable := map[int]bool{}
+	pending := []int{0}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if reachable[offset] || offset >= len(program.Code) {
+			continue
+		}
+
+		reachable[offset] = true
+		op, operands, _ := program.Decode(offset)
+		pending = append(pending, successors(offset, op, operands)...)
+	}
+
+	return rewrite(program, func(offset int, op Opcode, operands []int32) bool {
+		return reachable[offset] && !(op == OpJump && int(operands[0]) == offset + op.Width())
+	})
+}
+
+// rewrite drops the instructions keep rejects and moves jump targets and
+// lines along, a target of a dropped instruction moves to the next one kept
+func rewrite(program *Program, keep func(offset int, op Opcode, operands []int32) bool) bool {
+	offsets := make([]int32, len(program.Code) + 1)
+	code := []int32{}
+
+	for offset := 0; offset < len(program.Code); {
+		op, operands, _ := program.Decode(offset)
+		offsets[offset] = int32(len(code))
+
+		if keep(offset, op, operands) {
+			code = append(code, program.Code[offset:offset + op.Width()]...)
+		}
+
+		offset += op.Width()
+	}
+
+	offsets[len(program.Code)] = int32(len(code))
+
+	if len(code) == len(program.Code) {
+		return false
+	}
+
+	for offset := 0; offset < len(code); {
+		op := Opcode(code[offset])
+
+		for i, kind := range op.Info().Operands {
+			if kind == TargetOperand {
+				code[offset + 1 + i] = offsets[code[offset + 1 + i]]
+			}
+		}
+
+		offset += op.Width()
+	}
+
+	lines := []Line{}
+
+	for _, line := range program.Lines {
+		line.Offset = offsets[line.Offset]
+
+		if line.Offset >= int32(len(code)) {
+			break
+		} else if len(lines) > 0 && lines[len(lines)-1].Offset == line.Offset {
+			lines[len(lines)-1] = line
+		} else if len(lines) == 0 || lines[len(lines)-1].Location != line.Location {
+			lines = append(lines, line)
+		}
+	}
+
+	program.Code = code
+	program.Lines = lines
+	return true
+}
+
+// compactConstants drops constants nothing refers to anymore and merges
+// duplicates, like annotations that are used more than once
+func compactConstants(program *Program) {
+	constants := []interface{}{}
+	primitives := map[interface{}]int32{}
+	indexes := map[int32]int32{}
+
+	for offset := 0; offset < len(program.Code); {
+		op, operands, _ := program.Decode(offset)
+
+		for i, kind := range op.Info().Operands {
+			index := operands[i]
+
+			if kind != ConstOperand || index == -1 {
+				continue
+			} else if _, exist := indexes[index]; !exist {
+				indexes[index] = dedupe(&constants, primitives, program.Constants[index])
+			}
+
+			operands[i] = indexes[index]
+		}
+
+		offset += op.Width()
+	}
+
+	program.Constants = constants
+}
+
+func dedupe(constants *[]interface{}, primitives map[interface{}]int32, value interface{}) int32 {
+	switch value.(type) {
+	case nil, int, float64, string, bool:
+		if index, exist := primitives[value]; exist {
+			return index
+		}
+
+		primitives[value] = int32(len(*constants))
+		break
+	default:
+		for i, constant := range *constants {
+			if reflect.DeepEqual(constant, value) {
+				return int32(i)
+			}
+		}
+
+		break
+	}
+
+	*constants = append(*constants, value)
+	return int32(len(*constants) - 1)
+}
@@ -0,0 +1,25 @@
+package compiler
+
+type PatternKind int
+
+const (
+	WildcardPattern PatternKind = iota
+	LiteralPattern
+	TypePattern
+)
+
+type FieldPattern struct {
+	Name string
+	Slot int32
+	Pattern *Pattern
+}
+
+type Pattern struct {
+	Kind PatternKind
+	Type TypeId
+	TypeName string
+	Array bool
+	Optional bool
+	Value interface{}
+	Fields []FieldPattern
+}
@@ -0,0 +1,256 @@
+package compiler
+
+import (
+	"sort"
+	"crypto/sha256"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
+)
+
+type Opcode int32
+
+const (
+	OpLoadConst Opcode = iota
+	OpLoadVal
+	OpLoadSlot
+	OpLoadMember
+	OpLoadType
+	OpMakeField
+	OpMakeObject
+	OpMakeType
+	OpStoreVal
+	OpReassign
+	OpReassignSlot
+	OpSetMember
+	OpNewObject
+	OpSetField
+	OpSpreadObject
+	OpInitialize
+	OpMakeCall
+	OpOpenSection
+	OpCloseSection
+	OpAssert
+	OpCoalesce
+	OpIsNull
+	OpCompare
+	OpNot
+	OpJump
+	OpJumpIfFalse
+	OpPushFrame
+	OpPopFrame
+	OpNewArray
+	OpAppendArray
+	OpGetIter
+	OpForIter
+	OpPop
+	OpMatchPattern
+	OpMatchFail
+	OpcodeCount
+)
+
+type StoreFlags int32
+
+const (
+	StoreConst StoreFlags = 1 << iota
+	StoreTyped
+	StoreValue
+)
+
+type TypeFlags int32
+
+const (
+	TypeArray TypeFlags = 1 << iota
+	TypeOptional
+)
+
+type Operator int32
+
+const (
+	EqualOp Operator = iota
+	NotEqualOp
+)
+
+type TypeId int32
+
+const (
+	StringType TypeId = iota
+	IntegerType
+	BooleanType
+	FloatType
+	NullType
+	UserType
+)
+
+type StackEffect struct {
+	Pop int
+	Push int
+}
+
+type OperandKind int
+
+const (
+	IntOperand OperandKind = iota
+	NameOperand
+	ConstOperand
+	ScopeOperand
+	DepthOperand
+	SlotOperand
+	TargetOperand
+)
+
+type OpcodeInfo struct {
+	Name string
+	Operands []OperandKind
+	Effect func(operands []int32) StackEffect
+}
+
+func fixed(pop int, push int) func(operands []int32) StackEffect {
+	return func(operands []int32) StackEffect {
+		return StackEffect{Pop: pop, Push: push}
+	}
+}
+
+func operands(kinds ...OperandKind) []OperandKind {
+	return kinds
+}
+
+var opcodes = [OpcodeCount]OpcodeInfo{
+	OpLoadConst: {"LoadConst", operands(ConstOperand), fixed(0, 1)},
+	OpLoadVal: {"LoadVal", operands(NameOperand), fixed(0, 1)},
+	OpLoadSlot: {"LoadSlot", operands(DepthOperand, SlotOperand), fixed(0, 1)},
+	OpLoadMember: {"LoadMember", operands(NameOperand, IntOperand), fixed(1, 1)},
+	OpLoadType: {"LoadType", operands(IntOperand, IntOperand, NameOperand), fixed(0, 1)},
+	OpMakeField: {"MakeField", operands(NameOperand, ConstOperand, ConstOperand), fixed(1, 1)},
+	OpMakeObject: {"MakeObject", operands(IntOperand, IntOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: int(operands[0] + operands[1]), Push: 1}
+	}},
+	OpMakeType: {"MakeType", operands(NameOperand, ConstOperand, ConstOperand), fixed(1, 0)},
+	OpStoreVal: {"StoreVal", operands(SlotOperand, IntOperand, ConstOperand), func(operands []int32) StackEffect {
+		flags := StoreFlags(operands[1])
+		return StackEffect{Pop: btoi(flags & StoreTyped != 0) + btoi(flags & StoreValue != 0)}
+	}},
+	OpReassign: {"Reassign", operands(NameOperand, IntOperand), fixed(1, 0)},
+	OpReassignSlot: {"ReassignSlot", operands(DepthOperand, SlotOperand, IntOperand), fixed(1, 0)},
+	OpSetMember: {"SetMember", operands(NameOperand, IntOperand), fixed(2, 0)},
+	OpNewObject: {"NewObject", operands(IntOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: int(operands[0]), Push: 2}
+	}},
+	OpSetField: {"SetField", operands(NameOperand), fixed(3, 2)},
+	OpSpreadObject: {"SpreadObject", operands(), fixed(3, 2)},
+	OpInitialize: {"Initialize", operands(), fixed(2, 1)},
+	OpMakeCall: {"MakeCall", operands(IntOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: int(operands[0]) + 1, Push: 1}
+	}},
+	OpOpenSection: {"OpenSection", operands(NameOperand, IntOperand, ScopeOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: int(operands[1])}
+	}},
+	// The slot of CloseSection belongs to the parent of the section frame
+	OpCloseSection: {"CloseSection", operands(SlotOperand), fixed(0, 0)},
+	OpAssert: {"Assert", operands(), fixed(2, 0)},
+	OpCoalesce: {"Coalesce", operands(), fixed(2, 1)},
+	OpIsNull: {"IsNull", operands(), fixed(1, 1)},
+	OpCompare: {"Compare", operands(IntOperand), fixed(2, 1)},
+	OpNot: {"Not", operands(), fixed(1, 1)},
+	OpJump: {"Jump", operands(TargetOperand), fixed(0, 0)},
+	OpJumpIfFalse: {"JumpIfFalse", operands(TargetOperand), fixed(1, 0)},
+	OpPushFrame: {"PushFrame", operands(ScopeOperand), fixed(0, 0)},
+	OpPopFrame: {"PopFrame", operands(), fixed(0, 0)},
+	OpNewArray: {"NewArray", operands(), fixed(0, 1)},
+	OpAppendArray: {"AppendArray", operands(IntOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: int(operands[0]) + 2, Push: int(operands[0]) + 1}
+	}},
+	OpGetIter: {"GetIter", operands(), fixed(1, 1)},
+	OpForIter: {"ForIter", operands(TargetOperand, IntOperand), func(operands []int32) StackEffect {
+		return StackEffect{Pop: 1, Push: 2 + int(operands[1])}
+	}},
+	OpPop: {"Pop", operands(), fixed(1, 0)},
+	OpMatchPattern: {"MatchPattern", operands(ConstOperand), fixed(1, 2)},
+	OpMatchFail: {"MatchFail", operands(), fixed(1, 0)},
+}
+
+func (op Opcode) Info() *OpcodeInfo {
+	if op < 0 || op >= OpcodeCount {
+		return nil
+	}
+
+	return &opcodes[op]
+}
+
+func (op Opcode) Width() int {
+	return 1 + len(opcodes[op].Operands)
+}
+
+func (op Opcode) String() string {
+	if info := op.Info(); info != nil {
+		return info.Name
+	}
+
+	return "Unknown"
+}
+
+func btoi(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+
+type Line struct {
+	Offset int32
+	Location tokens.Location
+}
+
+// Scope describes the slot layout of a frame, the names are only kept for
+// debugging and lookups through the embedding API
+type Scope struct {
+	Names []string
+}
+
+type Program struct {
+	SourceHash [sha256.Size]byte
+	Code []int32
+	Constants []interface{}
+	Names []string
+	Scopes []Scope
+	Lines []Line
+}
+
+func (program *Program) Decode(offset int) (Opcode, []int32, bool) {
+	if offset < 0 || offset >= len(program.Code) {
+		return 0, nil, false
+	}
+
+	op := Opcode(program.Code[offset])
+
+	if op.Info() == nil || offset + op.Width() > len(program.Code) {
+		return op, nil, false
+	}
+
+	return op, program.Code[offset + 1:offset + op.Width()], true
+}
+
+func (program *Program) Loc(offset int) *tokens.Location {
+	index := sort.Search(len(program.Lines), func(i int) bool {
+		return int(program.Lines[i].Offset) > offset
+	}) - 1
+
+	if index < 0 || program.Lines[index].Location.Line == 0 {
+		return nil
+	}
+
+	loc := program.Lines[index].Location
+	return &loc
+}
+
+// CheckSource rejects a precompiled program when the sources it was compiled
+// from changed since, hash should be the hash of the current sources
+func (program *Program) CheckSource(hash [sha256.Size]byte) error {
+	if program.SourceHash != hash {
+		return diag.New(diag.InvalidBytecode, diag.Span{}, "Program is out of date with its sources").
+			WithNote("recompile the program with `-o`")
+	}
+
+	return nil
+}
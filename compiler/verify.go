@@ -0,0 +1,297 @@
+package compiler
+
+import (
+	"dmeijboom/config/diag"
+)
+
+// frameChain is the static chain of scopes the VM has pushed at an offset
+type frameChain struct {
+	scope int32
+	parent *frameChain
+}
+
+func (chain *frameChain) ancestor(depth int32) *frameChain {
+	for ; chain != nil && depth > 0; depth-- {
+		chain = chain.parent
+	}
+
+	return chain
+}
+
+
+type verifier struct {
+	program *Program
+	// depths holds the stack depth at each offset plus one, zero means unvisited
+	depths []int
+	frames []*frameChain
+	pending []int
+}
+
+func (verifier *verifier) fail(offset int, format string, args ...interface{}) error {
+	return diag.New(diag.InvalidBytecode, diag.At(verifier.program.Loc(offset)), format, args...)
+}
+
+func (verifier *verifier) enter(from int, offset int, depth int, frames *frameChain) error {
+	if offset < 0 || offset > len(verifier.program.Code) {
+		return verifier.fail(from, "Jump target %d out of range", offset)
+	} else if previous := verifier.depths[offset] - 1; previous == -1 {
+		verifier.depths[offset] = depth + 1
+		verifier.frames[offset] = frames
+		verifier.pending = append(verifier.pending, offset)
+	} else if previous != depth {
+		return verifier.fail(from, "Inconsistent stack depth at offset %d: %d and %d", offset, previous, depth)
+	} else if verifier.frames[offset] != frames {
+		return verifier.fail(from, "Inconsistent frames at offset %d", offset)
+	}
+
+	return nil
+}
+
+func (verifier *verifier) operands(offset int, op Opcode, operands []int32, frames *frameChain) error {
+	program := verifier.program
+	depth := int32(0)
+
+	// The slot of CloseSection refers to the frame the section is closed into
+	if op == OpCloseSection {
+		depth = 1
+	}
+
+	for i, kind := range op.Info().Operands {
+		index := operands[i]
+
+		if index == -1 && optionalOperand(op, i) {
+			continue
+		}
+
+		switch kind {
+		case ConstOperand:
+			if index < 0 || int(index) >= len(program.Constants) {
+				return verifier.fail(offset, "Constant %d out of range at offset %d", index, offset)
+			} else if err := verifier.constant(offset, op, i, index, frames); err != nil {
+				return err
+			}
+
+			break
+		case NameOperand:
+			if index < 0 || int(index) >= len(program.Names) {
+				return verifier.fail(offset, "Name %d out of range at offset %d", index, offset)
+			}
+
+			break
+		case ScopeOperand:
+			if index < 0 || int(index) >= len(program.Scopes) {
+				return verifier.fail(offset, "Scope %d out of range at offset %d", index, offset)
+			}
+
+			break
+		case DepthOperand:
+			depth = index
+			break
+		case SlotOperand:
+			frame := frames.ancestor(depth)
+
+			if depth < 0 || frame == nil || frame.scope == -1 || index < 0 || int(index) >= len(program.Scopes[frame.scope].Names) {
+				return verifier.fail(offset, "Slot %d at depth %d out of range at offset %d", index, depth, offset)
+			}
+
+			break
+		}
+	}
+
+	if op == OpLoadType && !validTypeId(TypeId(operands[0]), true) {
+		return verifier.fail(offset, "Type %d out of range at offset %d", operands[0], offset)
+	}
+
+	return nil
+}
+
+func validTypeId(typeId TypeId, user bool) bool {
+	return typeId >= StringType && (typeId < UserType || user && typeId == UserType)
+}
+
+func isLiteralConstant(value interface{}) bool {
+	switch value.(type) {
+	case nil, int, float64, string, bool:
+		return true
+	}
+
+	return false
+}
+
+// constant checks the kind of constant an operand refers to, as the VM
+// asserts it without checking
+func (verifier *verifier) constant(offset int, op Opcode, operand int, index int32, frames *frameChain) error {
+	value := verifier.program.Constants[index]
+	expected := "a literal"
+	valid := true
+
+	switch {
+	case op == OpMatchPattern:
+		pattern, isPattern := value.(*Pattern)
+
+		if !isPattern {
+			expected, valid = "a pattern", false
+			break
+		}
+
+		return verifier.pattern(offset, pattern, frames)
+	case (op == OpMakeField || op == OpMakeType) && operand == 1:
+		_, valid = value.(string)
+		expected = "a doc comment"
+		break
+	case op == OpMakeField || op == OpMakeType || op == OpStoreVal:
+		_, valid = value.([]Annotation)
+		expected = "a list of annotations"
+		break
+	default:
+		valid = isLiteralConstant(value)
+		break
+	}
+
+	if !valid {
+		return verifier.fail(offset, "Constant %d at offset %d is not %s", index, offset, expected)
+	}
+
+	return nil
+}
+
+func (verifier *verifier) pattern(offset int, pattern *Pattern, frames *frameChain) error {
+	switch pattern.Kind {
+	case WildcardPattern:
+		return nil
+	case LiteralPattern:
+		if validTypeId(pattern.Type, false) && isLiteralConstant(pattern.Value) {
+			return nil
+		}
+
+		break
+	case TypePattern:
+		if !validTypeId(pattern.Type, true) {
+			break
+		}
+
+		for _, field := range pattern.Fields {
+			if field.Pattern != nil {
+				if err := verifier.pattern(offset, field.Pattern, frames); err != nil {
+					return err
+				}
+			} else if frames.scope == -1 || field.Slot < 0 || int(field.Slot) >= len(verifier.program.Scopes[frames.scope].Names) {
+				return verifier.fail(offset, "Slot %d of field `%s` out of range at offset %d", field.Slot, field.Name, offset)
+			}
+		}
+
+		return nil
+	}
+
+	return verifier.fail(offset, "Invalid pattern at offset %d", offset)
+}
+
+// optionalOperand reports whether an operand can be -1 when it's absent,
+// like the doc comment of a field
+func optionalOperand(op Opcode, index int) bool {
+	switch op {
+	case OpLoadType, OpMakeField, OpMakeType, OpStoreVal:
+		return index > 0
+	}
+
+	return false
+}
+
+func analyze(program *Program) (*verifier, error) {
+	verifier := &verifier{
+		program: program,
+		depths: make([]int, len(program.Code) + 1),
+		frames: make([]*frameChain, len(program.Code) + 1),
+	}
+	root := &frameChain{scope: -1}
+
+	if len(program.Scopes) > 0 {
+		root.scope = 0
+	}
+
+	if err := verifier.enter(0, 0, 0, root); err != nil {
+		return verifier, err
+	}
+
+	for len(verifier.pending) > 0 {
+		offset := verifier.pending[len(verifier.pending)-1]
+		verifier.pending = verifier.pending[:len(verifier.pending)-1]
+
+		if offset == len(program.Code) {
+			continue
+		}
+
+		op, operands, ok := program.Decode(offset)
+
+		if !ok {
+			return verifier, verifier.fail(offset, "Invalid instruction at offset %d", offset)
+		}
+
+		frames := verifier.frames[offset]
+
+		if err := verifier.operands(offset, op, operands, frames); err != nil {
+			return verifier, err
+		}
+
+		var err error
+		depth := verifier.depths[offset] - 1
+		effect := op.Info().Effect(operands)
+		next := offset + op.Width()
+
+		if depth < effect.Pop {
+			return verifier, verifier.fail(offset, "Stack underflow at offset %d: %s pops %d with a depth of %d", offset, op, effect.Pop, depth)
+		}
+
+		after := depth - effect.Pop + effect.Push
+
+		switch op {
+		case OpPushFrame, OpOpenSection:
+			err = verifier.enter(offset, next, after, &frameChain{scope: operands[len(operands)-1], parent: frames})
+			break
+		case OpPopFrame, OpCloseSection:
+			if frames.parent == nil {
+				return verifier, verifier.fail(offset, "Cannot pop the root frame at offset %d", offset)
+			}
+
+			err = verifier.enter(offset, next, after, frames.parent)
+			break
+		case OpJump:
+			err = verifier.enter(offset, int(operands[0]), after, frames)
+			break
+		case OpJumpIfFalse:
+			if err = verifier.enter(offset, int(operands[0]), after, frames); err == nil {
+				err = verifier.enter(offset, next, after, frames)
+			}
+
+			break
+		case OpForIter:
+			if err = verifier.enter(offset, int(operands[0]), depth - 1, frames); err == nil {
+				err = verifier.enter(offset, next, after, frames)
+			}
+
+			break
+		case OpMatchFail:
+			break
+		default:
+			err = verifier.enter(offset, next, after, frames)
+			break
+		}
+
+		if err != nil {
+			return verifier, err
+		}
+	}
+
+	if depth := verifier.depths[len(program.Code)] - 1; depth > 0 {
+		return verifier, verifier.fail(len(program.Code) - 1, "Unbalanced stack at the end of the program: %d value(s) left", depth)
+	}
+
+	return verifier, nil
+}
+
+// Verify checks that every path through the program keeps the data stack
+// balanced, so the VM never pops an empty stack or leaks values
+func Verify(program *Program) error {
+	_, err := analyze(program)
+	return err
+}
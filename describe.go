@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"dmeijboom/config/tokens"
+)
+
+var tokenSymbols = map[tokens.TokenKind]string{
+	tokens.LBracket: "`{`",
+	tokens.RBracket: "`}`",
+	tokens.LParent: "`(`",
+	tokens.RParent: "`)`",
+	tokens.LSqrBracket: "`[`",
+	tokens.RSqrBracket: "`]`",
+	tokens.Colon: "`:`",
+	tokens.Comma: "`,`",
+	tokens.Query: "`?`",
+	tokens.OptionalChain: "`?.`",
+	tokens.Coalesce: "`??`",
+	tokens.Equals: "`=`",
+	tokens.PlusEquals: "`+=`",
+	tokens.Eq: "`==`",
+	tokens.NotEq: "`!=`",
+	tokens.Not: "`!`",
+	tokens.FatArrow: "`=>`",
+	tokens.Interpunct: "`.`",
+	tokens.Spread: "`...`",
+	tokens.At: "`@`",
+	tokens.Null: "`null`",
+	tokens.Ident: "identifier",
+	tokens.Keyword: "keyword",
+	tokens.String: "string",
+	tokens.Boolean: "boolean",
+	tokens.Integer: "integer",
+	tokens.Float: "float",
+	tokens.DocComment: "doc comment",
+	tokens.EndStmt: "EndStmt",
+}
+
+func describeKind(kind tokens.TokenKind, value ...interface{}) string {
+	if len(value) > 0 {
+		return fmt.Sprintf("`%v`", value[0])
+	} else if symbol, exist := tokenSymbols[kind]; exist {
+		return symbol
+	}
+
+	return kind.String()
+}
+
+func describeToken(token *tokens.Token) string {
+	if token == nil {
+		return "EOF"
+	}
+
+	switch token.Kind {
+	case tokens.Ident, tokens.Keyword:
+		return fmt.Sprintf("%s `%v`", describeKind(token.Kind), token.Value)
+	case tokens.String:
+		return fmt.Sprintf("string %q", token.Value)
+	case tokens.Boolean, tokens.Integer, tokens.Float:
+		return fmt.Sprintf("%s `%v`", describeKind(token.Kind), token.Value)
+	}
+
+	return describeKind(token.Kind)
+}
@@ -0,0 +1,202 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+	"encoding/json"
+	"dmeijboom/config/tokens"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+
+	return "unknown"
+}
+
+func (severity Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(severity.String())
+}
+
+
+type Code string
+
+const (
+	UnknownToken Code = "E0001"
+	UnfinishedString Code = "E0002"
+	InvalidNumber Code = "E0003"
+	SyntaxError Code = "E0100"
+	ImportError Code = "E0150"
+	DuplicateField Code = "E0200"
+	UninferableType Code = "E0201"
+	NonExhaustiveMatch Code = "E0202"
+	UnresolvedImport Code = "E0203"
+	TypeMismatch Code = "E0210"
+	UnknownField Code = "E0212"
+	InvalidCall Code = "E0213"
+	MissingField Code = "E0214"
+	ConstantReassignment Code = "E0215"
+	UndefinedName Code = "E0220"
+	DuplicateDeclaration Code = "E0221"
+	ShadowedName Code = "E0222"
+	RuntimeError Code = "E0300"
+	AssertionFailed Code = "E0301"
+	NameNotFound Code = "E0302"
+	ConstantAssignment Code = "E0303"
+	FrozenValue Code = "E0304"
+	NoMatchArm Code = "E0305"
+	ConflictingDefinition Code = "E0306"
+	InternalError Code = "E0900"
+	InvalidBytecode Code = "E0901"
+)
+
+
+type Span struct {
+	File string `json:"file,omitempty"`
+	Line int `json:"line"`
+	Column int `json:"column"`
+	Length int `json:"length"`
+}
+
+func At(loc *tokens.Location) Span {
+	if loc == nil {
+		return Span{}
+	}
+
+	return Span{
+		File: loc.File,
+		Line: loc.Line,
+		Column: loc.Column,
+		Length: 1,
+	}
+}
+
+func (span Span) IsZero() bool {
+	return span.Line == 0 && span.Column == 0
+}
+
+
+type Label struct {
+	Span Span `json:"span"`
+	Message string `json:"message"`
+}
+
+
+// TraceFrame is one of the frames that were active when a runtime error
+// occurred, Span points at where the frame was executing
+type TraceFrame struct {
+	Kind string `json:"kind"`
+	Function string `json:"function,omitempty"`
+	Section string `json:"section,omitempty"`
+	Span Span `json:"span"`
+}
+
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code Code `json:"code"`
+	Message string `json:"message"`
+	Span Span `json:"span"`
+	Labels []Label `json:"labels,omitempty"`
+	Notes []string `json:"notes,omitempty"`
+	Trace []TraceFrame `json:"trace,omitempty"`
+}
+
+func New(code Code, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code: code,
+		Message: fmt.Sprintf(format, args...),
+		Span: span,
+	}
+}
+
+func Warn(code Code, span Span, format string, args ...interface{}) *Diagnostic {
+	diagnostic := New(code, span, format, args...)
+	diagnostic.Severity = Warning
+
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) WithLabel(span Span, message string) *Diagnostic {
+	diagnostic.Labels = append(diagnostic.Labels, Label{Span: span, Message: message})
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) WithNote(note string) *Diagnostic {
+	diagnostic.Notes = append(diagnostic.Notes, note)
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) WithTrace(trace []TraceFrame) *Diagnostic {
+	diagnostic.Trace = trace
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) Error() string {
+	if diagnostic.Span.IsZero() {
+		return diagnostic.Message
+	}
+
+	return fmt.Sprintf("%s at %d:%d", diagnostic.Message, diagnostic.Span.Line, diagnostic.Span.Column)
+}
+
+
+type Diagnostics []*Diagnostic
+
+func (diagnostics Diagnostics) Error() string {
+	messages := []string{}
+
+	for _, diagnostic := range diagnostics {
+		messages = append(messages, diagnostic.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (diagnostics Diagnostics) InFile(file string) Diagnostics {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Span.File == "" {
+			diagnostic.Span.File = file
+		}
+
+		for i := range diagnostic.Labels {
+			if diagnostic.Labels[i].Span.File == "" {
+				diagnostic.Labels[i].Span.File = file
+			}
+		}
+	}
+
+	return diagnostics
+}
+
+func (diagnostics Diagnostics) JSON() ([]byte, error) {
+	return json.MarshalIndent(diagnostics, "", "  ")
+}
+
+func From(err error) Diagnostics {
+	switch diagnostic := err.(type) {
+	case nil:
+		return nil
+	case Diagnostics:
+		return diagnostic
+	case *Diagnostic:
+		return Diagnostics{diagnostic}
+	}
+
+	return Diagnostics{New(InternalError, Span{}, "%s", err.Error())}
+}
@@ -0,0 +1,169 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	colorReset = "\033[0m"
+	colorBold = "\033[1m"
+	colorRed = "\033[31m"
+	colorYellow = "\033[33m"
+	colorBlue = "\033[34m"
+	colorCyan = "\033[36m"
+)
+
+type Renderer struct {
+	Sources map[string]string
+	DefaultFile string
+	Color bool
+}
+
+func NewRenderer(defaultFile string, color bool) *Renderer {
+	return &Renderer{
+		Sources: map[string]string{},
+		DefaultFile: defaultFile,
+		Color: color,
+	}
+}
+
+func (renderer *Renderer) paint(color string, text string) string {
+	if !renderer.Color {
+		return text
+	}
+
+	return color + text + colorReset
+}
+
+func (renderer *Renderer) severityColor(severity Severity) string {
+	switch severity {
+	case Warning:
+		return colorYellow
+	case Note:
+		return colorCyan
+	}
+
+	return colorRed
+}
+
+func (renderer *Renderer) file(span Span) string {
+	if span.File == "" {
+		return renderer.DefaultFile
+	}
+
+	return span.File
+}
+
+func (renderer *Renderer) line(span Span) (string, bool) {
+	source, exist := renderer.Sources[renderer.file(span)]
+
+	if !exist || span.Line < 1 {
+		return "", false
+	}
+
+	lines := strings.Split(source, "\n")
+
+	if span.Line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[span.Line-1], "\r"), true
+}
+
+func (renderer *Renderer) snippet(w io.Writer, span Span, marker string, message string, color string, width int) {
+	line, exist := renderer.line(span)
+
+	if !exist {
+		return
+	}
+
+	gutter := strings.Repeat(" ", width)
+	padding := ""
+
+	for i, char := range line {
+		if i >= span.Column {
+			break
+		} else if char == '\t' {
+			padding += "\t"
+		} else {
+			padding += " "
+		}
+	}
+
+	length := span.Length
+
+	if length < 1 {
+		length = 1
+	}
+
+	fmt.Fprintf(w, "%s %s\n", gutter, renderer.paint(colorBlue, "|"))
+	fmt.Fprintf(w, "%s %s %s\n", renderer.paint(colorBlue, fmt.Sprintf("%*d", width, span.Line)), renderer.paint(colorBlue, "|"), line)
+	fmt.Fprintf(w, "%s %s %s%s\n", gutter, renderer.paint(colorBlue, "|"), padding, renderer.paint(color, strings.TrimRight(strings.Repeat(marker, length) + " " + message, " ")))
+}
+
+func (renderer *Renderer) traceFrame(frame TraceFrame) string {
+	var text string
+
+	switch frame.Kind {
+	case "function":
+		text = fmt.Sprintf("in function `%s`", frame.Function)
+		break
+	case "section":
+		text = fmt.Sprintf("in section `%s`", frame.Section)
+		break
+	default:
+		text = "in " + frame.Kind
+		break
+	}
+
+	if frame.Section != "" && frame.Kind != "section" {
+		text += fmt.Sprintf(" of section `%s`", frame.Section)
+	}
+
+	if frame.Span.IsZero() {
+		return text
+	}
+
+	return fmt.Sprintf("%s at %s:%d:%d", text, renderer.file(frame.Span), frame.Span.Line, frame.Span.Column)
+}
+
+func (renderer *Renderer) Render(w io.Writer, diagnostics Diagnostics) {
+	for _, diagnostic := range diagnostics {
+		color := renderer.severityColor(diagnostic.Severity)
+		width := len(fmt.Sprint(diagnostic.Span.Line))
+
+		for _, label := range diagnostic.Labels {
+			if labelWidth := len(fmt.Sprint(label.Span.Line)); labelWidth > width {
+				width = labelWidth
+			}
+		}
+
+		fmt.Fprintf(w, "%s%s\n", renderer.paint(colorBold + color, fmt.Sprintf("%s[%s]", diagnostic.Severity, diagnostic.Code)), renderer.paint(colorBold, ": " + diagnostic.Message))
+
+		if !diagnostic.Span.IsZero() {
+			fmt.Fprintf(w, "%s %s:%d:%d\n", renderer.paint(colorBlue, strings.Repeat(" ", width) + "-->"), renderer.file(diagnostic.Span), diagnostic.Span.Line, diagnostic.Span.Column)
+			renderer.snippet(w, diagnostic.Span, "^", "", color, width)
+		}
+
+		for _, label := range diagnostic.Labels {
+			renderer.snippet(w, label.Span, "-", label.Message, colorBlue, width)
+		}
+
+		for _, note := range diagnostic.Notes {
+			fmt.Fprintf(w, "%s %s %s\n", strings.Repeat(" ", width), renderer.paint(colorBlue, "="), renderer.paint(colorBold, "note:") + " " + note)
+		}
+
+		// A trace of just the root frame doesn't add anything to the span
+		if len(diagnostic.Trace) > 1 {
+			fmt.Fprintf(w, "%s %s %s\n", strings.Repeat(" ", width), renderer.paint(colorBlue, "="), renderer.paint(colorBold, "trace:"))
+
+			for _, frame := range diagnostic.Trace {
+				fmt.Fprintf(w, "%s     %s\n", strings.Repeat(" ", width), renderer.traceFrame(frame))
+			}
+		}
+
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	"dmeijboom/config/vm"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/compiler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexerDiagnostics(t *testing.T) {
+	_, err := NewFileLexer("main.cf", "let a = 1\nlet b = #").Lex()
+
+	if assert.IsType(t, &diag.Diagnostic{}, err) {
+		diagnostic := err.(*diag.Diagnostic)
+
+		assert.Equal(t, diag.UnknownToken, diagnostic.Code)
+		assert.Equal(t, diag.Span{File: "main.cf", Line: 2, Column: 8, Length: 1}, diagnostic.Span)
+	}
+}
+
+func TestCompilerDiagnostics(t *testing.T) {
+	_, err := compileSource(t, filesystemType + `let fs: Filesystem = new {
+		uuid = "root"
+		uuid = "boot"
+	}`)
+
+	if assert.IsType(t, &diag.Diagnostic{}, err) {
+		diagnostic := err.(*diag.Diagnostic)
+
+		assert.Equal(t, diag.DuplicateField, diagnostic.Code)
+		assert.Equal(t, 15, diagnostic.Span.Line)
+
+		if assert.Equal(t, 1, len(diagnostic.Labels)) {
+			assert.Equal(t, 14, diagnostic.Labels[0].Span.Line)
+		}
+	}
+}
+
+func TestRuntimeDiagnostics(t *testing.T) {
+	_, err := evalSource(t, `const root = "/"
+	root = "/boot"`)
+
+	if assert.IsType(t, &diag.Diagnostic{}, err) {
+		diagnostic := err.(*diag.Diagnostic)
+
+		assert.Equal(t, diag.ConstantAssignment, diagnostic.Code)
+		assert.Equal(t, 2, diagnostic.Span.Line)
+		assert.Equal(t, 1, len(diagnostic.Notes))
+	}
+}
+
+func TestRenderDiagnostics(t *testing.T) {
+	source := "let a = 1\nlet b: int 5\n"
+	diagnostics := diag.Diagnostics{
+		diag.New(diag.SyntaxError, diag.Span{Line: 2, Column: 11, Length: 1}, "expected `=`").
+			WithLabel(diag.Span{Line: 1, Column: 4, Length: 1}, "previous binding").
+			WithNote("a let needs a value or a type"),
+	}
+
+	renderer := diag.NewRenderer("main.cf", false)
+	renderer.Sources["main.cf"] = source
+
+	output := &bytes.Buffer{}
+	renderer.Render(output, diagnostics)
+
+	assert.Equal(t, `error[E0100]: expected `+"`=`"+`
+ --> main.cf:2:11
+  |
+2 | let b: int 5
+  |            ^
+  |
+1 | let a = 1
+  |     - previous binding
+  = note: a let needs a value or a type
+
+`, output.String())
+
+	data, err := diagnostics.JSON()
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	decoded := []map[string]interface{}{}
+	json.Unmarshal(data, &decoded)
+
+	assert.Equal(t, "error", decoded[0]["severity"])
+	assert.Equal(t, "E0100", decoded[0]["code"])
+	assert.Equal(t, float64(2), decoded[0]["span"].(map[string]interface{})["line"])
+}
+
+func TestRuntimeStackTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.cf": "import \"storage.cf\"\n",
+		"storage.cf": "storage {\n\tlet disks: []string = []\n\tvolumes {\n\t\tif true {\n\t\t\tdisks.add(1)\n\t\t}\n\t}\n}\n",
+	}
+
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	loader := NewLoader()
+	source, err := loader.Load(filepath.Join(dir, "main.cf"))
+
+	if !assert.Nil(t, err, "Loading imports shouldn't fail") {
+		return
+	}
+
+	program, err := compiler.NewCompiler(source).Compile()
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	machine := vm.NewVm(program)
+	setBuiltins(machine)
+	err = machine.Run()
+
+	if !assert.IsType(t, &diag.Diagnostic{}, err) {
+		return
+	}
+
+	storage := filepath.Join(dir, "storage.cf")
+	diagnostic := err.(*diag.Diagnostic)
+
+	assert.Equal(t, []diag.TraceFrame{
+		{Kind: "function", Function: "add", Section: "storage.volumes", Span: diag.Span{File: storage, Line: 5, Column: 3, Length: 1}},
+		{Kind: "block", Section: "storage.volumes", Span: diag.Span{File: storage, Line: 5, Column: 3, Length: 1}},
+		{Kind: "section", Section: "storage.volumes", Span: diag.Span{File: storage, Line: 5, Column: 3, Length: 1}},
+		{Kind: "section", Section: "storage", Span: diag.Span{File: storage, Line: 3, Column: 1, Length: 1}},
+		{Kind: "root", Span: diag.Span{File: storage, Line: 1, Column: 0, Length: 1}},
+	}, diagnostic.Trace)
+
+	renderer := diag.NewRenderer("main.cf", false)
+	output := &bytes.Buffer{}
+	renderer.Render(output, diag.Diagnostics{diagnostic})
+
+	assert.Contains(t, output.String(), "= trace:\n")
+	assert.Contains(t, output.String(), "in function `add` of section `storage.volumes` at " + storage + ":5:3\n")
+	assert.Contains(t, output.String(), "in root at " + storage + ":1:0\n")
+}
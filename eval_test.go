@@ -0,0 +1,1135 @@
+package main
+
+import (
+	"os"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"dmeijboom/config/vm"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/compiler"
+	"github.com/stretchr/testify/assert"
+)
+
+func compileSource(t *testing.T, input string) (*compiler.Program, error) {
+	source, errLexer, errParser := tokenizeAndParse(input)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		t.FailNow()
+	}
+
+	return compiler.NewCompiler(source).Compile()
+}
+
+func evalSource(t *testing.T, input string) (*vm.VirtualMachine, error) {
+	program, err := compileSource(t, input)
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		t.FailNow()
+	}
+
+	machine := vm.NewVm(program)
+	setBuiltins(machine)
+
+	return machine, machine.Run()
+}
+
+func objectField(value *vm.Value, name string) interface{} {
+	return value.Value.(*vm.Object).Fields[name].Value
+}
+
+func TestEvalAssert(t *testing.T) {
+	_, err := evalSource(t, `let mounted: bool = true
+	assert mounted, "root must be mounted"`)
+
+	assert.Nil(t, err, "Passing assertion shouldn't fail")
+
+	_, err = evalSource(t, `let mounted: bool = false
+	assert mounted, "root must be mounted"
+	assert false, "home must be mounted"
+	assert true, "never reported"`)
+
+	if assert.IsType(t, diag.Diagnostics{}, err, "Failed assertions should be collected") {
+		failures := err.(diag.Diagnostics)
+
+		assert.Equal(t, 2, len(failures), "All failed assertions should be reported")
+		assert.Equal(t, diag.AssertionFailed, failures[0].Code)
+		assert.Equal(t, "Assertion failed: root must be mounted", failures[0].Message)
+		assert.Equal(t, 2, failures[0].Span.Line)
+		assert.Equal(t, "Assertion failed: home must be mounted", failures[1].Message)
+		assert.Equal(t, 3, failures[1].Span.Line)
+	}
+
+	_, err = evalSource(t, `let mounted: bool = false
+	assert mounted, "root must be mounted"
+	let paths: []string = ["/"]
+	paths += 10`)
+
+	if assert.IsType(t, diag.Diagnostics{}, err, "Failed assertions should be reported with the runtime error") {
+		failures := err.(diag.Diagnostics)
+
+		if assert.Equal(t, 2, len(failures)) {
+			assert.Equal(t, diag.AssertionFailed, failures[0].Code)
+			assert.Equal(t, diag.RuntimeError, failures[1].Code)
+			assert.Equal(t, 4, failures[1].Span.Line)
+		}
+	}
+}
+
+const filesystemType = `type Filesystem: object {
+	uuid: string
+	path: string
+	fstype: string
+	opts: string?
+}
+
+let base: Filesystem = new {
+	uuid = "root"
+	path = "/"
+	fstype = "btrfs"
+}
+`
+
+func TestEvalSpread(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	let home: Filesystem = new { ...base, path = "/home" }
+	let boot: Filesystem = base with { path = "/boot", fstype = "vfat" }`)
+
+	if !assert.Nil(t, err, "Spread shouldn't fail") {
+		return
+	}
+
+	home := machine.Get("home")
+	assert.Equal(t, "root", objectField(home, "uuid"))
+	assert.Equal(t, "/home", objectField(home, "path"))
+	assert.Equal(t, "btrfs", objectField(home, "fstype"))
+
+	boot := machine.Get("boot")
+	assert.Equal(t, "/boot", objectField(boot, "path"))
+	assert.Equal(t, "vfat", objectField(boot, "fstype"))
+	assert.Equal(t, "/", objectField(machine.Get("base"), "path"), "Base object shouldn't be modified")
+
+	machine, err = evalSource(t, `type Mount: object {
+		path: string
+	}
+
+	type Volume: object {
+		tags: []string
+		mount: Mount
+	}
+
+	let template = new Volume { tags = ["a"], mount = new Mount { path = "/" } }
+	let data: Volume = template with {}
+	data.tags += "b"
+	data.mount.path = "/data"`)
+
+	if assert.Nil(t, err, "Spreading containers shouldn't fail") {
+		template := machine.Get("template")
+		assert.Equal(t, 1, len(objectField(template, "tags").(*vm.Array).Values()), "Base arrays shouldn't be modified")
+		mount := template.Value.(*vm.Object).Fields["mount"]
+		assert.Equal(t, "/", objectField(mount, "path"), "Base nested objects shouldn't be modified")
+	}
+
+	_, err = evalSource(t, filesystemType + `
+	let home: Filesystem = base with { mountpoint = "/home" }`)
+	assert.NotNil(t, err, "Unknown fields should be rejected")
+
+	_, err = evalSource(t, filesystemType + `
+	let home = new Filesystem { uuid = "home", path = 5, fstype = "ext4" }`)
+	assert.NotNil(t, err, "Fields of the wrong type should be rejected")
+
+	_, err = evalSource(t, filesystemType + `
+	let home: Filesystem = base with { path = true }`)
+	assert.NotNil(t, err, "Overriding fields with the wrong type should be rejected")
+
+	_, err = compileSource(t, filesystemType + `
+	let home: Filesystem = base with { path = "/home", path = "/srv" }`)
+	assert.NotNil(t, err, "Duplicate fields should be rejected")
+
+	_, err = evalSource(t, filesystemType + `
+	type Disk: object {
+		path: string
+	}
+
+	let disk: Disk = new { ...base }`)
+	assert.NotNil(t, err, "Spreading another object type should be rejected")
+}
+
+func TestEvalExtends(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	type EncryptedFs: Filesystem {
+		keyfile: string
+	}
+
+	let filesystems: []Filesystem
+	let crypt: EncryptedFs = new {
+		uuid = "crypt"
+		path = "/home"
+		fstype = "ext4"
+		keyfile = "/etc/crypt.key"
+	}
+	let home: Filesystem = crypt
+
+	filesystems.add(base)
+	filesystems.add(crypt)`)
+
+	if !assert.Nil(t, err, "Derived types should be assignable to their base") {
+		return
+	}
+
+	filesystems := machine.Get("filesystems").Value.(*vm.Array).Values()
+	assert.Equal(t, 2, len(filesystems))
+	assert.Equal(t, "EncryptedFs", filesystems[1].Type.Name)
+	assert.Equal(t, "/etc/crypt.key", objectField(machine.Get("home"), "keyfile"))
+
+	_, err = evalSource(t, filesystemType + `
+	type EncryptedFs: Filesystem {
+		keyfile: string
+	}
+
+	let crypt: EncryptedFs = base`)
+	assert.NotNil(t, err, "Base types shouldn't be assignable to derived types")
+
+	_, err = evalSource(t, filesystemType + `
+	type Disk: object {
+		path: string
+	}
+
+	let disks: []Disk
+	disks.add(base)`)
+
+	if assert.NotNil(t, err, "Unrelated types shouldn't be added to arrays") {
+		assert.Contains(t, err.Error(), "Cannot add Filesystem to []Disk")
+	}
+
+	_, err = evalSource(t, filesystemType + `
+	type EncryptedFs: Filesystem {
+		path: string
+	}`)
+	assert.NotNil(t, err, "Derived types shouldn't redefine base fields")
+}
+
+func TestEvalInference(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	let filesystems: []Filesystem
+	let port = 8080
+	let name = "server"
+	let home = new Filesystem {
+		uuid = "home"
+		path = "/home"
+		fstype = "ext4"
+	}
+	let boot = base with { path = "/boot" }
+
+	filesystems.add(new Filesystem { uuid = "srv", path = "/srv", fstype = "xfs" })`)
+
+	if !assert.Nil(t, err, "Inferred lets shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, "int", machine.Get("port").Type.Name)
+	assert.Equal(t, "string", machine.Get("name").Type.Name)
+	assert.Equal(t, "Filesystem", machine.Get("home").Type.Name)
+	assert.Equal(t, "Filesystem", machine.Get("boot").Type.Name)
+
+	filesystems := machine.Get("filesystems").Value.(*vm.Array).Values()
+
+	if assert.Equal(t, 1, len(filesystems)) {
+		assert.Equal(t, "/srv", objectField(filesystems[0], "path"))
+	}
+
+	_, err = compileSource(t, `let home = new { path = "/home" }`)
+	assert.NotNil(t, err, "Untyped initializers without a let type should be rejected")
+
+	_, err = evalSource(t, `let port: string = 8080`)
+	assert.NotNil(t, err, "Literal types should be checked")
+}
+
+func TestEvalNull(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	let missing: Filesystem? = null
+	let opts = base.opts ?? "defaults"
+	let missingPath = missing?.path ?? "none"
+	let tmp = base with { opts = "size=1G" }
+	let tmpOpts = tmp.opts ?? "defaults"
+
+	assert base.opts is null, "absent optional fields should be null"
+	assert missing is null, "null lets should be null"`)
+
+	if !assert.Nil(t, err, "Null handling shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, "defaults", machine.Get("opts").Value)
+	assert.Equal(t, "none", machine.Get("missingPath").Value)
+	assert.Equal(t, "size=1G", machine.Get("tmpOpts").Value)
+
+	_, err = evalSource(t, `let name: string = null`)
+	assert.NotNil(t, err, "Null shouldn't be assignable to non-optional types")
+
+	_, err = evalSource(t, filesystemType + `
+	let missing: Filesystem? = null
+	let path = missing.path`)
+	assert.NotNil(t, err, "Reading a field of null should fail")
+
+	_, err = evalSource(t, filesystemType + `
+	let root = base with { path = null }`)
+	assert.NotNil(t, err, "Non-optional fields can't be null")
+}
+
+func TestEvalConditional(t *testing.T) {
+	machine, err := evalSource(t, `let env = "prod"
+	let port = if env == "prod" then 80 else 8080
+	let debug = if env != "prod" then true else false
+	let level = if env == "dev" then "debug" else if env == "test" then "info" else "warn"
+
+	if env == "prod" {
+		let inner = "hidden"
+		assert !debug, "debug should be disabled in prod"
+	} else {
+		assert false, "never reached"
+	}
+
+	if debug {
+		assert false, "never reached"
+	}`)
+
+	if !assert.Nil(t, err, "Conditionals shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, 80, machine.Get("port").Value)
+	assert.Equal(t, false, machine.Get("debug").Value)
+	assert.Equal(t, "warn", machine.Get("level").Value)
+	assert.Nil(t, machine.Get("inner"), "Block bindings shouldn't leak")
+
+	_, err = evalSource(t, `let env = "dev"
+	if env {
+	}`)
+	assert.NotNil(t, err, "Conditions should be boolean")
+
+	_, err = evalSource(t, `let debug = true
+	if debug {
+		assert false, "debug build"
+	} else {
+		assert false, "release build"
+	}`)
+
+	if assert.IsType(t, diag.Diagnostics{}, err) {
+		assert.Equal(t, 1, len(err.(diag.Diagnostics)), "Only the taken branch should run")
+		assert.Equal(t, "Assertion failed: debug build", err.(diag.Diagnostics)[0].Message)
+	}
+}
+
+func TestEvalLoops(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	type Disk: object {
+		uuid: string
+		ssd: bool
+	}
+
+	let disks = [
+		new Disk { uuid = "a", ssd = true },
+		new Disk { uuid = "b", ssd = false },
+		new Disk { uuid = "c", ssd = true }]
+	let mounts: []Filesystem = [new Filesystem { uuid = d.uuid, path = "/mnt", fstype = "ext4" } for d in disks if d.ssd]
+	let uuids: []string = []
+	let names: []string = []
+
+	for i, disk in disks {
+		let uuid = disk.uuid
+		uuids.add(uuid)
+	}
+
+	for name, value in base {
+		names.add(name)
+	}`)
+
+	if !assert.Nil(t, err, "Loops shouldn't fail") {
+		return
+	}
+
+	mounts := machine.Get("mounts").Value.(*vm.Array).Values()
+
+	if assert.Equal(t, 2, len(mounts)) {
+		assert.Equal(t, "a", objectField(mounts[0], "uuid"))
+		assert.Equal(t, "c", objectField(mounts[1], "uuid"))
+	}
+
+	uuids := []interface{}{}
+
+	for _, uuid := range machine.Get("uuids").Value.(*vm.Array).Values() {
+		uuids = append(uuids, uuid.Value)
+	}
+
+	names := []interface{}{}
+
+	for _, name := range machine.Get("names").Value.(*vm.Array).Values() {
+		names = append(names, name.Value)
+	}
+
+	assert.Equal(t, []interface{}{"a", "b", "c"}, uuids)
+	assert.Equal(t, []interface{}{"fstype", "path", "uuid"}, names, "Objects should iterate in key order")
+	assert.Nil(t, machine.Get("disk"), "Loop bindings shouldn't leak")
+
+	_, err = evalSource(t, `let count = 3
+	for i in count {
+	}`)
+	assert.NotNil(t, err, "Only arrays and objects are iterable")
+
+	_, err = evalSource(t, `let mixed = ["a", 1]`)
+	assert.NotNil(t, err, "Array elements should share a type")
+}
+
+func TestEvalMatch(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	type EncryptedFs: Filesystem {
+		keyfile: string
+	}
+
+	let crypt = new EncryptedFs {
+		uuid = "crypt"
+		path = "/home"
+		fstype = "ext4"
+		keyfile = "/etc/crypt.key"
+	}
+	let env = "prod"
+
+	let port = match env {
+		"dev" => 8080
+		"prod" => 80
+		_ => 8000
+	}
+	let rootType = match base {
+		Filesystem { path = "/", fstype } => fstype
+		_ => "none"
+	}
+	let key = match crypt {
+		EncryptedFs { keyfile } => keyfile
+		Filesystem => "plain"
+		_ => "none"
+	}
+	let plain = match base {
+		EncryptedFs => "encrypted"
+		Filesystem => "plain"
+		_ => "none"
+	}
+	let secure = match env == "prod" {
+		true => "yes"
+		false => "no"
+	}`)
+
+	if !assert.Nil(t, err, "Match shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, 80, machine.Get("port").Value)
+	assert.Equal(t, "btrfs", machine.Get("rootType").Value)
+	assert.Equal(t, "/etc/crypt.key", machine.Get("key").Value)
+	assert.Equal(t, "plain", machine.Get("plain").Value)
+	assert.Equal(t, "yes", machine.Get("secure").Value)
+	assert.Nil(t, machine.Get("fstype"), "Pattern bindings shouldn't leak")
+
+	_, err = compileSource(t, `let env = "prod"
+	let port = match env {
+		"dev" => 8080
+		"prod" => 80
+	}`)
+
+	if assert.NotNil(t, err, "Literal matches without a wildcard are non-exhaustive") {
+		assert.Contains(t, err.Error(), "at 2:12")
+	}
+
+	_, err = compileSource(t, filesystemType + `
+	type Disk: object {
+		path: string
+	}
+
+	let path = match base {
+		Disk { path } => path
+		Filesystem => base.path
+	}`)
+
+	if assert.NotNil(t, err, "Type patterns without a wildcard are non-exhaustive") {
+		assert.Equal(t, diag.NonExhaustiveMatch, diag.From(err)[0].Code)
+		assert.Contains(t, err.Error(), "at 18:12")
+	}
+
+	_, err = evalSource(t, `let path = match "/" {
+		true => "yes"
+		false => "no"
+	}`)
+	assert.NotNil(t, err, "Unmatched values should fail")
+}
+
+func TestEvalReassign(t *testing.T) {
+	machine, err := evalSource(t, filesystemType + `
+	let paths: []string = ["/"]
+	paths += "/home"
+	paths += ["/boot", "/tmp"]
+	let fs = base with {}
+	fs.path = "/boot"
+	fs.opts = "noatime"
+	let count: int = 1
+	count = 2`)
+
+	if !assert.Nil(t, err, "Reassignment shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, 4, len(machine.Get("paths").Value.(*vm.Array).Values()))
+	assert.Equal(t, "/boot", objectField(machine.Get("fs"), "path"))
+	assert.Equal(t, "noatime", objectField(machine.Get("fs"), "opts"))
+	assert.Equal(t, 2, machine.Get("count").Value)
+	assert.True(t, machine.Get("fs").Frozen(), "Exported objects should be frozen")
+
+	machine, err = evalSource(t, filesystemType + `
+	const root = base
+	let alias = base
+	alias.path = "/boot"
+	let mounts: []string = ["/"]
+	const initial = mounts
+	mounts += "/home"`)
+
+	if assert.Nil(t, err, "Constants shouldn't freeze the values they were bound from") {
+		assert.Equal(t, "/boot", objectField(machine.Get("base"), "path"))
+		assert.Equal(t, "/", objectField(machine.Get("root"), "path"))
+		assert.Equal(t, 2, len(machine.Get("mounts").Value.(*vm.Array).Values()))
+		assert.Equal(t, 1, len(machine.Get("initial").Value.(*vm.Array).Values()))
+	}
+
+	failures := map[string]string{
+		`const root: string = "/"
+		root = "/boot"`: "Cannot assign to constant `root` at 2:",
+		filesystemType + `const fs: Filesystem = base with {}
+		fs.path = "/boot"`: "Cannot modify frozen Filesystem at 14:",
+		`let count: int = 1
+		count = "two"`: "Cannot assign string to `count` of type int at 2:",
+		`let paths: []string = []
+		paths += 1`: "Cannot add int to []string at 2:",
+	}
+
+	for source, message := range failures {
+		_, err = evalSource(t, source)
+
+		if assert.NotNil(t, err, "Invalid reassignment should fail") {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+func TestEvalSection(t *testing.T) {
+	machine, err := evalSource(t, `type Server: object {
+		host: string
+		port: int
+		tls: bool?
+	}
+
+	server: Server {
+		let host = "localhost"
+		let port = 8080
+	}
+
+	logging {
+		let level = "debug"
+		output {
+			let path = "/var/log"
+		}
+	}
+
+	let port = server.port
+	assert logging.output.path == "/var/log", "nested sections should be readable"`)
+
+	if !assert.Nil(t, err, "Sections shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, 8080, machine.Get("port").Value)
+	assert.Equal(t, "Server", machine.Get("server").Type.Name)
+	assert.Equal(t, "localhost", objectField(machine.Get("server"), "host"))
+	assert.Equal(t, "debug", objectField(machine.Get("logging"), "level"))
+
+	_, err = evalSource(t, `type Server: object {
+		port: int
+	}
+
+	server: Server {
+		let port = "80"
+	}`)
+
+	if assert.NotNil(t, err, "Invalid section field should fail") {
+		assert.Contains(t, err.Error(), "Cannot use `port` type string as type int at 5:")
+	}
+
+	_, err = evalSource(t, `type Server: object {
+		port: int
+	}
+
+	server: Server {}`)
+
+	if assert.NotNil(t, err, "Missing section field should fail") {
+		assert.Contains(t, err.Error(), "Section `server` is missing the non-optional `port` field")
+	}
+}
+
+func TestEvalSectionMerge(t *testing.T) {
+	machine, err := evalSource(t, `network {
+		let hostname = "node"
+		let dns: []string = ["1.1.1.1"]
+		let routes: []string = ["default"]
+		interfaces {
+			let primary = "eth0"
+		}
+	}
+
+	network {
+		hostname = "node-1"
+		dns += "8.8.8.8"
+		routes = ["10.0.0.0/8"]
+		let mtu = 1500
+		interfaces {
+			let secondary = "eth1"
+		}
+	}`)
+
+	if !assert.Nil(t, err, "Merging sections shouldn't fail") {
+		return
+	}
+
+	network := machine.Get("network")
+	interfaces := objectField(network, "interfaces").(*vm.Object)
+
+	assert.Equal(t, "node-1", objectField(network, "hostname"))
+	assert.Equal(t, 1500, objectField(network, "mtu"))
+	assert.Equal(t, 2, len(objectField(network, "dns").(*vm.Array).Values()))
+	assert.Equal(t, "10.0.0.0/8", objectField(network, "routes").(*vm.Array).Values()[0].Value)
+	assert.Equal(t, "eth0", interfaces.Fields["primary"].Value)
+	assert.Equal(t, "eth1", interfaces.Fields["secondary"].Value)
+
+	_, err = evalSource(t, `network {
+		let hostname = "node"
+	}
+
+	network {
+		let hostname = "other"
+	}`)
+
+	if assert.NotNil(t, err, "Redefining a section field should fail") {
+		assert.Contains(t, err.Error(), "Conflicting definition of `hostname` in section `network`")
+	}
+
+	_, err = evalSource(t, `type Server: object {
+		host: string
+		port: int
+	}
+
+	server: Server {
+		let host = "localhost"
+	}
+
+	server {
+		let port = 8080
+	}`)
+
+	assert.Nil(t, err, "Typed sections should be validated after merging")
+}
+
+func TestEvalSlots(t *testing.T) {
+	machine, err := evalSource(t, `type Server: object {
+		port: int
+		tls: bool?
+	}
+
+	let name = "outer"
+	let count = 1
+
+	if name == "outer" {
+		let inner = name
+		let name = "inner"
+		count = 2
+		assert inner == "outer", "names should resolve to the outer binding before they're shadowed"
+		assert name == "inner", "shadowed names should resolve to the inner binding"
+	}
+
+	server: Server {
+		let port = 80
+	}
+
+	server.tls = true
+
+	server {
+		assert tls == true, "fields set after closing a section should be visible when reopening it"
+		port = 443
+	}`)
+
+	if !assert.Nil(t, err, "Slot resolution shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, "outer", machine.Get("name").Value)
+	assert.Equal(t, 2, machine.Get("count").Value)
+	assert.Equal(t, 443, objectField(machine.Get("server"), "port"))
+	assert.Equal(t, true, objectField(machine.Get("server"), "tls"))
+
+	_, err = evalSource(t, `writeln(missing)
+	let missing = 1`)
+
+	if assert.NotNil(t, err, "Reading a binding before it's declared should fail") {
+		assert.Contains(t, err.Error(), "Name `missing` not found")
+	}
+}
+
+func TestLoaderImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.cf": "import \"network.cf\"\nimport \"dns.cf\"\n",
+		"network.cf": "network {\n\tlet hostname = \"node\"\n\tlet dns: []string = []\n}\n",
+		"dns.cf": "import \"network.cf\"\nnetwork {\n\tdns += \"1.1.1.1\"\n}\n",
+		"cycle.cf": "import \"cycle.cf\"\n",
+	}
+
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	loader := NewLoader()
+	source, err := loader.Load(filepath.Join(dir, "main.cf"))
+
+	if !assert.Nil(t, err, "Loading imports shouldn't fail") {
+		return
+	}
+
+	program, err := compiler.NewCompiler(source).Compile()
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	program.SourceHash = loader.Hash()
+	assert.Nil(t, NewLoader().CheckSource(program, filepath.Join(dir, "main.cf")), "Unchanged sources should match the program")
+
+	ioutil.WriteFile(filepath.Join(dir, "dns.cf"), []byte(files["dns.cf"] + "let port = 53\n"), 0644)
+	err = NewLoader().CheckSource(program, filepath.Join(dir, "main.cf"))
+
+	if assert.NotNil(t, err, "Changed imports should make the program stale") {
+		assert.Contains(t, err.Error(), "Program is out of date with its sources")
+	}
+
+	machine := vm.NewVm(program)
+
+	if assert.Nil(t, machine.Run(), "Merged imports shouldn't fail") {
+		network := machine.Get("network")
+		assert.Equal(t, "node", objectField(network, "hostname"))
+		assert.Equal(t, 1, len(objectField(network, "dns").(*vm.Array).Values()))
+	}
+
+	_, err = NewLoader().Load(filepath.Join(dir, "cycle.cf"))
+
+	if assert.NotNil(t, err, "Import cycles should fail") {
+		assert.Contains(t, err.Error(), "Import cycle on `cycle.cf`")
+	}
+}
+
+func TestEvalAnnotations(t *testing.T) {
+	program, err := compileSource(t, `@doc("A mounted filesystem")
+	type Filesystem: object {
+		@env("TEST_ROOT_UUID")
+		uuid: string
+		@secret
+		path: string
+	}
+
+	@secret
+	let password = "hunter2"
+	let fs: Filesystem = new { path = "/" }`)
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	os.Setenv("TEST_ROOT_UUID", "1234-abcd")
+	defer os.Unsetenv("TEST_ROOT_UUID")
+
+	secrets := []string{}
+	machine := vm.NewVm(program)
+	setBuiltins(machine)
+	machine.RegisterAnnotation("secret", func(annotation *vm.Annotation, name string, value *vm.Value) (*vm.Value, error) {
+		secrets = append(secrets, name)
+		return nil, nil
+	})
+
+	if !assert.Nil(t, machine.Run(), "Annotated program shouldn't fail") {
+		return
+	}
+
+	fs := machine.Get("fs")
+	assert.Equal(t, "1234-abcd", objectField(fs, "uuid"), "@env should fill the field")
+	assert.Equal(t, []string{"password", "path"}, secrets)
+	assert.Equal(t, "A mounted filesystem", fs.Type.Annotation("doc").Args[0])
+	assert.NotNil(t, fs.Type.ObjectDef.FieldByName("path").Annotation("secret"))
+	assert.Nil(t, fs.Type.ObjectDef.FieldByName("uuid").Annotation("secret"))
+	assert.Equal(t, "secret", machine.Annotations("password")[0].Name)
+}
+
+func TestEvalDocComments(t *testing.T) {
+	machine, err := evalSource(t, `/// A mounted filesystem
+	type Filesystem: object {
+		/// Mount point
+		path: string
+	}
+
+	let fs: Filesystem = new { path = "/" }`)
+
+	if !assert.Nil(t, err, "Documented program shouldn't fail") {
+		return
+	}
+
+	fsType := machine.Get("fs").Type
+	assert.Equal(t, "A mounted filesystem", fsType.Doc)
+	assert.Equal(t, "Mount point", fsType.ObjectDef.FieldByName("path").Doc)
+}
+
+func TestVerifyBytecode(t *testing.T) {
+	program, err := compileSource(t, filesystemType + `
+	writeln(base.path)
+	base.fstype
+	[fs.uuid for fs in [base] if fs.opts == null]
+	match base.path {
+		"/" => "root"
+		_ => "other"
+	}`)
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	assert.Nil(t, compiler.Verify(program), "Expression statements should leave the stack balanced")
+
+	err = compiler.Verify(&compiler.Program{
+		Code: []int32{
+			int32(compiler.OpLoadConst), 0,
+			int32(compiler.OpPop),
+			int32(compiler.OpPop),
+		},
+		Constants: []interface{}{1},
+	})
+
+	if assert.NotNil(t, err, "Popping an empty stack should be rejected") {
+		assert.Contains(t, err.Error(), "Stack underflow at offset 3")
+	}
+
+	err = compiler.Verify(&compiler.Program{
+		Code: []int32{
+			int32(compiler.OpLoadConst), 0,
+			int32(compiler.OpJumpIfFalse), 6,
+			int32(compiler.OpLoadConst), 1,
+			int32(compiler.OpNewArray),
+			int32(compiler.OpPop),
+		},
+		Constants: []interface{}{true, 1},
+	})
+
+	if assert.NotNil(t, err, "Branches with different depths should be rejected") {
+		assert.Contains(t, err.Error(), "Inconsistent stack depth at offset 6")
+	}
+
+	err = compiler.Verify(&compiler.Program{
+		Code: []int32{int32(compiler.OpLoadConst), 3},
+		Constants: []interface{}{1},
+	})
+
+	assert.NotNil(t, err, "Constant operands out of range should be rejected")
+
+	err = vm.NewVm(&compiler.Program{
+		Code: []int32{int32(compiler.OpLoadConst), 0},
+		Constants: []interface{}{"leak"},
+	}).Run()
+
+	if assert.IsType(t, &diag.Diagnostic{}, err, "Run should verify the program first") {
+		assert.Equal(t, diag.InvalidBytecode, err.(*diag.Diagnostic).Code)
+	}
+
+	invalid := map[string]*compiler.Program{
+		"Type 99 out of range at offset 0": {
+			Code: []int32{int32(compiler.OpLoadType), 99, 0, -1, int32(compiler.OpPop)},
+		},
+		"Constant 0 at offset 2 is not a pattern": {
+			Code: []int32{int32(compiler.OpLoadConst), 0, int32(compiler.OpMatchPattern), 0, int32(compiler.OpPop), int32(compiler.OpPop)},
+			Constants: []interface{}{"/"},
+		},
+		"Constant 0 at offset 4 is not a doc comment": {
+			Code: []int32{int32(compiler.OpLoadType), 0, 0, -1, int32(compiler.OpMakeField), 0, 0, -1, int32(compiler.OpPop)},
+			Constants: []interface{}{1},
+			Names: []string{"path"},
+		},
+		"Constant 0 at offset 2 is not a list of annotations": {
+			Code: []int32{int32(compiler.OpLoadConst), 0, int32(compiler.OpStoreVal), 0, int32(compiler.StoreValue), 0},
+			Constants: []interface{}{"/"},
+			Scopes: []compiler.Scope{{Names: []string{"path"}}},
+		},
+	}
+
+	err = vm.NewVm(&compiler.Program{
+		Code: []int32{
+			int32(compiler.OpLoadConst), 0,
+			int32(compiler.OpLoadConst), 0,
+			int32(compiler.OpLoadConst), 0,
+			int32(compiler.OpSpreadObject),
+			int32(compiler.OpPop),
+			int32(compiler.OpPop),
+		},
+		Constants: []interface{}{1},
+	}).Run()
+
+	if assert.IsType(t, &diag.Diagnostic{}, err, "Values of the wrong type shouldn't crash the vm") {
+		assert.Equal(t, diag.InvalidBytecode, err.(*diag.Diagnostic).Code)
+	}
+
+	for message, program := range invalid {
+		err = vm.NewVm(program).Run()
+
+		if assert.IsType(t, &diag.Diagnostic{}, err, "Constants of the wrong kind should be rejected") {
+			assert.Equal(t, diag.InvalidBytecode, err.(*diag.Diagnostic).Code)
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+func TestEncodeProgram(t *testing.T) {
+	program, err := compileSource(t, filesystemType + `
+	@secret
+	let old = base with { opts = "ro" }
+	let ratio = 0.5
+	let kind = match base {
+		Filesystem { path } => path
+		_ => "other"
+	}`)
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if !assert.Nil(t, compiler.Encode(&buf, program), "Encoding shouldn't fail") {
+		return
+	}
+
+	data := buf.Bytes()
+	decoded, err := compiler.Decode(bytes.NewReader(data))
+
+	if !assert.Nil(t, err, "Decoding shouldn't fail") {
+		return
+	}
+
+	assert.Equal(t, program.Code, decoded.Code)
+	assert.Equal(t, program.Names, decoded.Names)
+	assert.Equal(t, program.Lines, decoded.Lines)
+
+	machine := vm.NewVm(decoded)
+	setBuiltins(machine)
+
+	if assert.Nil(t, machine.Run(), "Decoded program shouldn't fail") {
+		assert.Equal(t, "/", machine.Get("kind").Value)
+		assert.Equal(t, 0.5, machine.Get("ratio").Value)
+		assert.Equal(t, "secret", machine.Annotations("old")[0].Name)
+	}
+
+	mismatch := append([]byte{}, data...)
+	mismatch[len(compiler.Magic)] = compiler.FormatVersion + 1
+	_, err = compiler.Decode(bytes.NewReader(mismatch))
+
+	if assert.NotNil(t, err, "Other format versions should be rejected") {
+		assert.Contains(t, err.Error(), fmt.Sprintf("Unsupported bytecode version %d", compiler.FormatVersion + 1))
+	}
+
+	_, err = compiler.Decode(bytes.NewReader(data[:len(data)/2]))
+	assert.NotNil(t, err, "Truncated programs should be rejected")
+
+	_, err = compiler.Decode(bytes.NewReader(append(append([]byte{}, data...), 0)))
+
+	if assert.NotNil(t, err, "Trailing data should be rejected") {
+		assert.Contains(t, err.Error(), "trailing data after the line table")
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let paths = ["/", "/boot"]
+	for path in paths {
+		writeln(path)
+	}`
+	program, err := compileSource(t, input)
+
+	if !assert.Nil(t, err, "Compiler shouldn't fail") {
+		return
+	}
+
+	var buf bytes.Buffer
+	disassembler := compiler.NewDisassembler(program)
+	disassembler.Sources[""] = input
+
+	if !assert.Nil(t, disassembler.Disassemble(&buf), "Disassembling shouldn't fail") {
+		return
+	}
+
+	output := buf.String()
+	assert.Contains(t, output, ":1 | let paths = [\"/\", \"/boot\"]\n")
+	assert.Contains(t, output, "[1]  LoadConst     1 (\"/boot\")\n")
+	assert.Contains(t, output, "[1]  StoreVal      0 (paths), 4, -1\n")
+	assert.Contains(t, output, ":2 | for path in paths {\n")
+	assert.Contains(t, output, "[1]  ForIter       -> 37, 0\n")
+	assert.Contains(t, output, "[2]  PushFrame     1 {path}\n")
+	assert.Contains(t, output, ":3 | writeln(path)\n")
+	assert.Contains(t, output, "[1]  LoadSlot      0, 0 (path)\n")
+	assert.Contains(t, output, "[2]  LoadVal       0 (writeln)\n")
+}
+
+func TestOptimize(t *testing.T) {
+	input := filesystemType + `
+	type Unused: object {
+		name: string
+	}
+
+	let enabled = true
+	let kind = if "a" == "a" then "same" else "other"
+	let missing = null ?? "fallback"
+	let negated = !false
+	let mounts: []string = []
+
+	if false {
+		writeln("never")
+	}
+
+	if enabled {
+		let unused = [1, 2]
+		mounts.add("/")
+	} else {
+		mounts.add("/mnt")
+	}
+
+	for fs in [base] {
+		let ignored = "x"
+		mounts.add(fs.path)
+	}
+
+	let label = match base {
+		Filesystem { path = "/" } => "root"
+		_ => "other"
+	}`
+	programs := []*compiler.Program{}
+	values := [][]interface{}{}
+
+	for _, optimize := range []bool{false, true} {
+		source, errLexer, errParser := tokenizeAndParse(input)
+
+		if !assert.Nil(t, errLexer, "Lexer shouldn't fail") || !assert.Nil(t, errParser, "Parser shouldn't fail") {
+			return
+		}
+
+		compiler_ := compiler.NewCompiler(source)
+		compiler_.Optimize = optimize
+		program, err := compiler_.Compile()
+
+		if !assert.Nil(t, err, "Compiler shouldn't fail") || !assert.Nil(t, compiler.Verify(program), "Optimized bytecode should verify") {
+			return
+		}
+
+		machine := vm.NewVm(program)
+		setBuiltins(machine)
+
+		if !assert.Nil(t, machine.Run(), "Program shouldn't fail") {
+			return
+		}
+
+		result := []interface{}{}
+
+		for _, name := range []string{"kind", "missing", "negated", "label"} {
+			result = append(result, machine.Get(name).Value)
+		}
+
+		for _, mount := range machine.Get("mounts").Value.(*vm.Array).Values() {
+			result = append(result, mount.Value)
+		}
+
+		programs = append(programs, program)
+		values = append(values, result)
+	}
+
+	assert.Equal(t, []interface{}{"same", "fallback", true, "root", "/", "/"}, values[0])
+	assert.Equal(t, values[0], values[1], "Optimizing shouldn't change the results")
+	assert.True(t, len(programs[1].Code) < len(programs[0].Code), "Dead code should be removed")
+	assert.True(t, len(programs[1].Constants) < len(programs[0].Constants), "Unused constants should be removed")
+	assert.NotContains(t, programs[1].Names, "Unused")
+
+	source, _, _ := tokenizeAndParse("if true {\n\tlet mixed = [\"a\", 1]\n}")
+	compiler_ := compiler.NewCompiler(source)
+	compiler_.Optimize = true
+	program, err := compiler_.Compile()
+
+	if assert.Nil(t, err, "Compiler shouldn't fail") {
+		assert.NotNil(t, vm.NewVm(program).Run(), "Bindings that fail at runtime shouldn't be optimized away")
+	}
+}
+
+
+func largeConfig(entries int) string {
+	var builder strings.Builder
+
+	builder.WriteString(filesystemType)
+
+	for i := 0; i < entries; i++ {
+		fmt.Fprintf(&builder, `
+		let fs%d = new Filesystem {
+			...base
+			uuid = "uuid-%d"
+			path = "/mnt/%d"
+		}
+		mount%d {
+			let path = fs%d.path
+			let opts = fs%d.opts ?? "defaults"
+			let root = if fs%d.path == "/" then true else false
+			let tags = [tag for tag in ["a", "b", "c"] if tag != "b"]
+		}
+		assert mount%d.path != "", "path must be set"
+		`, i, i, i, i, i, i, i, i)
+	}
+
+	return builder.String()
+}
+
+func BenchmarkEvalLargeConfig(b *testing.B) {
+	source, errLexer, errParser := tokenizeAndParse(largeConfig(1000))
+
+	if errLexer != nil || errParser != nil {
+		b.Fatal(errLexer, errParser)
+	}
+
+	program, err := compiler.NewCompiler(source).Compile()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		machine := vm.NewVm(program)
+		setBuiltins(machine)
+
+		if err := machine.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
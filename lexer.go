@@ -1,28 +1,36 @@
 package main
 
 import (
-	"fmt"
 	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
+	"dmeijboom/config/diag"
 	"dmeijboom/config/tokens"
 )
 
 var keywords = []string{
-	"type", "let", "new",
+	"type", "let", "const", "new", "assert", "with", "is",
+	"if", "then", "else", "for", "in", "match", "import",
 }
 
 type Lexer struct {
 	pos int
 	line int
 	col int
+	file string
 	input string
+	nesting []tokens.TokenKind
 }
 
 func NewLexer(input string) *Lexer {
 	return &Lexer{input: input, line: 1}
 }
 
+func NewFileLexer(file string, input string) *Lexer {
+	return &Lexer{input: input, line: 1, file: file}
+}
+
 func (lexer *Lexer) eof() bool {
 	return lexer.pos >= len(lexer.input)
 }
@@ -46,6 +54,14 @@ func (lexer *Lexer) next() rune {
 	return r
 }
 
+func (lexer *Lexer) span() diag.Span {
+	return diag.At(&tokens.Location{
+		Line: lexer.line,
+		Column: lexer.col,
+		File: lexer.file,
+	})
+}
+
 func (lexer *Lexer) ident() string {
 	ident := ""
 
@@ -59,6 +75,61 @@ func (lexer *Lexer) ident() string {
 	return ident
 }
 
+func (lexer *Lexer) comment() string {
+	comment := ""
+
+	for !lexer.eof() && lexer.current() != '\n' {
+		comment += string(lexer.next())
+	}
+
+	return comment
+}
+
+func (lexer *Lexer) open(kind tokens.TokenKind) {
+	lexer.nesting = append(lexer.nesting, kind)
+}
+
+func (lexer *Lexer) close() {
+	if len(lexer.nesting) > 0 {
+		lexer.nesting = lexer.nesting[:len(lexer.nesting)-1]
+	}
+}
+
+func (lexer *Lexer) inExprGroup() bool {
+	if len(lexer.nesting) == 0 {
+		return false
+	}
+
+	kind := lexer.nesting[len(lexer.nesting)-1]
+	return kind == tokens.LParent || kind == tokens.LSqrBracket
+}
+
+func (lexer *Lexer) continuesLine() bool {
+	rest := strings.TrimLeft(lexer.input[lexer.pos:], " \t\r\n")
+
+	if strings.HasPrefix(rest, "...") {
+		return false
+	}
+
+	for _, prefix := range []string{".", "?.", "??", "==", "!="} {
+		if strings.HasPrefix(rest, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (lexer *Lexer) atLineStart() bool {
+	line := lexer.input[:lexer.pos]
+
+	if index := strings.LastIndex(line, "\n"); index >= 0 {
+		line = line[index+1:]
+	}
+
+	return strings.TrimSpace(line) == ""
+}
+
 func (lexer *Lexer) number() (tokens.Token, error) {
 	num := ""
 	is_float := false
@@ -77,17 +148,27 @@ func (lexer *Lexer) number() (tokens.Token, error) {
 	
 	if is_float {
 		floatval, err := strconv.ParseFloat(num, 64)
+
+		if err != nil {
+			return tokens.Token{}, diag.New(diag.InvalidNumber, lexer.span(), "Invalid float literal `%s`", num)
+		}
+
 		return tokens.Token{
 			Kind: tokens.Float,
 			Value: floatval,
-		}, err
+		}, nil
 	}
 
 	numval, err := strconv.Atoi(num)
+
+	if err != nil {
+		return tokens.Token{}, diag.New(diag.InvalidNumber, lexer.span(), "Invalid integer literal `%s`", num)
+	}
+
 	return tokens.Token{
 		Kind: tokens.Integer,
 		Value: numval,
-	}, err
+	}, nil
 }
 
 func (lexer *Lexer) string() (tokens.Token, error) {
@@ -98,7 +179,7 @@ func (lexer *Lexer) string() (tokens.Token, error) {
 	loop:
 	for {
 		if lexer.eof() {
-			return tokens.Token{}, fmt.Errorf("Unfinished string literal at %d:%d", lexer.line, lexer.pos)
+			return tokens.Token{}, diag.New(diag.UnfinishedString, lexer.span(), "Unfinished string literal")
 		}
 
 		current := lexer.current()
@@ -136,14 +217,14 @@ func (lexer *Lexer) isKeyword(ident string) bool {
 
 func (lexer *Lexer) shouldInsertEndStmt(token *tokens.Token) bool {
 	switch token.Kind {
-		case tokens.Keyword,
-			tokens.RParent,
+		case tokens.RParent,
 			tokens.RBracket,
 			tokens.RSqrBracket,
 			tokens.Query,
 			tokens.Ident,
 			tokens.String,
 			tokens.Boolean,
+			tokens.Null,
 			tokens.Integer,
 			tokens.Float:
 			return true
@@ -168,47 +249,134 @@ func (lexer *Lexer) Lex() ([]tokens.Token, error) {
 			token, err = lexer.string()
 			break
 		case '.':
+			if strings.HasPrefix(lexer.input[lexer.pos:], "...") {
+				token = tokens.Token{Kind: tokens.Spread}
+				lexer.pos += 3
+				lexer.col += 3
+				break
+			}
+
 			token = tokens.Token{Kind: tokens.Interpunct}
 			lexer.next()
 			break
 		case '=':
+			if strings.HasPrefix(lexer.input[lexer.pos:], "==") {
+				token = tokens.Token{Kind: tokens.Eq}
+				lexer.pos += 2
+				lexer.col += 2
+				break
+			} else if strings.HasPrefix(lexer.input[lexer.pos:], "=>") {
+				token = tokens.Token{Kind: tokens.FatArrow}
+				lexer.pos += 2
+				lexer.col += 2
+				break
+			}
+
 			token = tokens.Token{Kind: tokens.Equals}
 			lexer.next()
 			break
+		case '!':
+			if strings.HasPrefix(lexer.input[lexer.pos:], "!=") {
+				token = tokens.Token{Kind: tokens.NotEq}
+				lexer.pos += 2
+				lexer.col += 2
+				break
+			}
+
+			token = tokens.Token{Kind: tokens.Not}
+			lexer.next()
+			break
+		case '+':
+			if !strings.HasPrefix(lexer.input[lexer.pos:], "+=") {
+				return nil, diag.New(diag.UnknownToken, lexer.span(), "Unknown token %q", lexer.current())
+			}
+
+			token = tokens.Token{Kind: tokens.PlusEquals}
+			lexer.pos += 2
+			lexer.col += 2
+			break
 		case '{':
 			token = tokens.Token{Kind: tokens.LBracket}
+			lexer.open(tokens.LBracket)
 			lexer.next()
 			break
 		case '}':
 			token = tokens.Token{Kind: tokens.RBracket}
+			lexer.close()
 			lexer.next()
 			break
 		case '(':
 			token = tokens.Token{Kind: tokens.LParent}
+			lexer.open(tokens.LParent)
 			lexer.next()
 			break
 		case ')':
 			token = tokens.Token{Kind: tokens.RParent}
+			lexer.close()
+			lexer.next()
+			break
+		case ';':
+			token = tokens.Token{Kind: tokens.EndStmt}
 			lexer.next()
 			break
 		case ':':
 			token = tokens.Token{Kind: tokens.Colon}
 			lexer.next()
 			break
+		case ',':
+			token = tokens.Token{Kind: tokens.Comma}
+			lexer.next()
+			break
+		case '/':
+			if !strings.HasPrefix(lexer.input[lexer.pos:], "//") {
+				return nil, diag.New(diag.UnknownToken, lexer.span(), "Unknown token %q", lexer.current())
+			}
+
+			isDoc := strings.HasPrefix(lexer.input[lexer.pos:], "///") && lexer.atLineStart()
+			comment := lexer.comment()
+
+			if !isDoc {
+				continue loop
+			}
+
+			token = tokens.Token{
+				Kind: tokens.DocComment,
+				Value: strings.TrimSpace(strings.TrimPrefix(comment, "///")),
+			}
+			break
+		case '@':
+			token = tokens.Token{Kind: tokens.At}
+			lexer.next()
+			break
 		case '?':
+			if strings.HasPrefix(lexer.input[lexer.pos:], "?.") {
+				token = tokens.Token{Kind: tokens.OptionalChain}
+				lexer.pos += 2
+				lexer.col += 2
+				break
+			} else if strings.HasPrefix(lexer.input[lexer.pos:], "??") {
+				token = tokens.Token{Kind: tokens.Coalesce}
+				lexer.pos += 2
+				lexer.col += 2
+				break
+			}
+
 			token = tokens.Token{Kind: tokens.Query}
 			lexer.next()
 			break
 		case '[':
 			token = tokens.Token{Kind: tokens.LSqrBracket}
+			lexer.open(tokens.LSqrBracket)
 			lexer.next()
 			break
 		case ']':
 			token = tokens.Token{Kind: tokens.RSqrBracket}
+			lexer.close()
 			lexer.next()
 			break
 		case '\n':
-			if lexer.shouldInsertEndStmt(&tokenList[len(tokenList)-1]) {
+			if len(tokenList) > 0 && !lexer.inExprGroup() && !lexer.continuesLine() &&
+				lexer.shouldInsertEndStmt(&tokenList[len(tokenList)-1]) {
 				token = tokens.Token{Kind: tokens.EndStmt}
 				lexer.next()
 				break
@@ -223,7 +391,7 @@ func (lexer *Lexer) Lex() ([]tokens.Token, error) {
 			if unicode.IsNumber(current) {
 				token, err = lexer.number()
 				break
-			} else if unicode.IsLetter(current) {
+			} else if unicode.IsLetter(current) || current == '_' {
 				ident := lexer.ident()
 
 				if ident == "true" || ident == "false" {
@@ -231,6 +399,8 @@ func (lexer *Lexer) Lex() ([]tokens.Token, error) {
 						Kind: tokens.Boolean,
 						Value: ident == "true",
 					}
+				} else if ident == "null" {
+					token = tokens.Token{Kind: tokens.Null}
 				} else if lexer.isKeyword(ident) {
 					token = tokens.Token{
 						Kind: tokens.Keyword,
@@ -245,7 +415,7 @@ func (lexer *Lexer) Lex() ([]tokens.Token, error) {
 				break
 			}
 			
-			return nil, fmt.Errorf("Unknown token %q at %d:%d", lexer.current(), lexer.line, lexer.pos)
+			return nil, diag.New(diag.UnknownToken, lexer.span(), "Unknown token %q", lexer.current())
 		}
 
 		if err != nil {
@@ -255,16 +425,18 @@ func (lexer *Lexer) Lex() ([]tokens.Token, error) {
 		token.Loc = &tokens.Location{
 			Line: lexer.line,
 			Column: start_pos,
+			File: lexer.file,
 		}
 		tokenList = append(tokenList, token)
 	}
 
-	if lexer.shouldInsertEndStmt(&tokenList[len(tokenList)-1]) {
+	if len(tokenList) > 0 && lexer.shouldInsertEndStmt(&tokenList[len(tokenList)-1]) {
 		tokenList = append(tokenList, tokens.Token{
 			Kind: tokens.EndStmt,
 			Loc: &tokens.Location{
 				Line: lexer.line,
 				Column: lexer.col,
+				File: lexer.file,
 			},
 		})
 	}
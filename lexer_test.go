@@ -112,3 +112,103 @@ func TestAutoEndStmt(t *testing.T) {
 		{tokens.EndStmt, nil, nil},
 	})
 }
+
+func TestNullOperatorTokens(t *testing.T) {
+	lexCmp(t, "fs?.opts ?? null", []tokens.Token{
+		{Kind: tokens.Ident, Value: "fs"},
+		{Kind: tokens.OptionalChain},
+		{Kind: tokens.Ident, Value: "opts"},
+		{Kind: tokens.Coalesce},
+		{Kind: tokens.Null},
+		{Kind: tokens.EndStmt},
+	})
+
+	lexCmp(t, "opts: string?", []tokens.Token{
+		{Kind: tokens.Ident, Value: "opts"},
+		{Kind: tokens.Colon},
+		{Kind: tokens.Ident, Value: "string"},
+		{Kind: tokens.Query},
+		{Kind: tokens.EndStmt},
+	})
+}
+
+func TestComments(t *testing.T) {
+	lexCmp(t, `// header
+	/// Mount point
+	/// of the filesystem
+	path: string // trailing /// comment`, []tokens.Token{
+		{Kind: tokens.DocComment, Value: "Mount point"},
+		{Kind: tokens.DocComment, Value: "of the filesystem"},
+		{Kind: tokens.Ident, Value: "path"},
+		{Kind: tokens.Colon},
+		{Kind: tokens.Ident, Value: "string"},
+		{Kind: tokens.EndStmt},
+	})
+}
+
+func TestNewlineRules(t *testing.T) {
+	lexCmp(t, `add(a,
+		b)
+	let xs = [
+		1
+	]; let y = fs
+		?.opts
+		?? "defaults"`, []tokens.Token{
+		{Kind: tokens.Ident, Value: "add"},
+		{Kind: tokens.LParent},
+		{Kind: tokens.Ident, Value: "a"},
+		{Kind: tokens.Comma},
+		{Kind: tokens.Ident, Value: "b"},
+		{Kind: tokens.RParent},
+		{Kind: tokens.EndStmt},
+		{Kind: tokens.Keyword, Value: "let"},
+		{Kind: tokens.Ident, Value: "xs"},
+		{Kind: tokens.Equals},
+		{Kind: tokens.LSqrBracket},
+		{Kind: tokens.Integer, Value: 1},
+		{Kind: tokens.RSqrBracket},
+		{Kind: tokens.EndStmt},
+		{Kind: tokens.Keyword, Value: "let"},
+		{Kind: tokens.Ident, Value: "y"},
+		{Kind: tokens.Equals},
+		{Kind: tokens.Ident, Value: "fs"},
+		{Kind: tokens.OptionalChain},
+		{Kind: tokens.Ident, Value: "opts"},
+		{Kind: tokens.Coalesce},
+		{Kind: tokens.String, Value: "defaults"},
+		{Kind: tokens.EndStmt},
+	})
+
+	lexCmp(t, `writeln(new {
+		a = 1
+	})`, []tokens.Token{
+		{Kind: tokens.Ident, Value: "writeln"},
+		{Kind: tokens.LParent},
+		{Kind: tokens.Keyword, Value: "new"},
+		{Kind: tokens.LBracket},
+		{Kind: tokens.Ident, Value: "a"},
+		{Kind: tokens.Equals},
+		{Kind: tokens.Integer, Value: 1},
+		{Kind: tokens.EndStmt},
+		{Kind: tokens.RBracket},
+		{Kind: tokens.RParent},
+		{Kind: tokens.EndStmt},
+	})
+
+	lexCmp(t, "", []tokens.Token{})
+	lexCmp(t, "\n\n// only a comment\n", []tokens.Token{})
+
+	for _, input := range []string{
+		`if true { writeln("a") }`,
+		`server { let a = 1 }`,
+		`network { let dns: []string }`,
+		`type Disk: object { path: string }`,
+		`for x in [1, 2] { writeln(x) }`,
+		`checks { assert true, "ok" }`,
+	} {
+		_, errLexer, errParser := tokenizeAndParse(input)
+
+		assert.Nil(t, errLexer, "Lexer shouldn't fail")
+		assert.Nil(t, errParser, "A closing bracket should end the last statement of a block in: %s", input)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"io/ioutil"
+	"crypto/sha256"
+	"path/filepath"
+	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/compiler"
+)
+
+type Loader struct {
+	Sources map[string]string
+	loaded map[string]bool
+	loading map[string]bool
+}
+
+func NewLoader() *Loader {
+	return &Loader{
+		Sources: map[string]string{},
+		loaded: map[string]bool{},
+		loading: map[string]bool{},
+	}
+}
+
+func (loader *Loader) Load(filename string) (*ast.Source, error) {
+	content, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, diag.New(diag.ImportError, diag.Span{File: filename}, "Cannot read `%s`: %s", filename, err.Error())
+	}
+
+	loader.Sources[filename] = string(content)
+	lexer := NewFileLexer(filename, string(content))
+	tokens, err := lexer.Lex()
+
+	if err != nil {
+		return nil, diag.From(err).InFile(filename)
+	}
+
+	parser := NewParser(tokens)
+	source, err := parser.Parse()
+
+	if err != nil {
+		return nil, diag.From(err).InFile(filename)
+	}
+
+	return source, loader.Resolve(source, filename)
+}
+
+func (loader *Loader) Resolve(source *ast.Source, filename string) error {
+	path, err := filepath.Abs(filename)
+
+	if err != nil {
+		return err
+	}
+
+	loader.loading[path] = true
+	defer delete(loader.loading, path)
+
+	body := []ast.Node{}
+
+	for _, node := range source.Block.Body {
+		import_, isImport := node.(*ast.Import)
+
+		if !isImport {
+			body = append(body, node)
+			continue
+		}
+
+		importPath := filepath.Join(filepath.Dir(path), import_.Path.Value.(string))
+
+		if loader.loading[importPath] {
+			span := diag.At(import_.Loc())
+			span.File = filename
+			return diag.New(diag.ImportError, span, "Import cycle on `%s`", import_.Path.Value)
+		} else if loader.loaded[importPath] {
+			continue
+		}
+
+		imported, err := loader.Load(importPath)
+
+		if err != nil {
+			return err
+		}
+
+		body = append(body, imported.Block.Body...)
+	}
+
+	loader.loaded[path] = true
+	source.Block.Body = body
+	return nil
+}
+
+// Hash identifies the sources a program was compiled from, including imports.
+// Imports are loaded by their absolute path, so the main file is as well and
+// the hash doesn't depend on how it was passed on the command line
+func (loader *Loader) Hash() [sha256.Size]byte {
+	hash := sha256.New()
+	sources := map[string]string{}
+	filenames := []string{}
+
+	for filename, content := range loader.Sources {
+		if path, err := filepath.Abs(filename); err == nil {
+			filename = path
+		}
+
+		sources[filename] = content
+		filenames = append(filenames, filename)
+	}
+
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		hash.Write([]byte(filename))
+		hash.Write([]byte{0})
+		hash.Write([]byte(sources[filename]))
+		hash.Write([]byte{0})
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// CheckSource loads filename and its imports and rejects the program when it
+// was compiled from other sources
+func (loader *Loader) CheckSource(program *compiler.Program, filename string) error {
+	if _, err := loader.Load(filename); err != nil {
+		return err
+	}
+
+	return program.CheckSource(loader.Hash())
+}
@@ -1,64 +1,224 @@
 package main
 
 import (
+	"os"
 	"fmt"
+	"flag"
+	"strings"
 	"io/ioutil"
-	"reflect"
-	"encoding/json"
+	"path/filepath"
 	"dmeijboom/config/vm"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/checker"
+	"dmeijboom/config/resolver"
 	"dmeijboom/config/compiler"
-	"github.com/davecgh/go-spew/spew"
 )
 
+var jsonOutput = flag.Bool("json", false, "Report diagnostics as JSON")
+var warnShadowing = flag.Bool("warn-shadow", true, "Warn when a declaration shadows an outer one")
+var disassembleOnly = flag.Bool("disassemble", false, "Print the disassembled program instead of running it")
+var outputFile = flag.String("o", "", "Write the compiled program to a `.cfc` file instead of running it")
+var optimize = flag.Bool("optimize", true, "Fold constants and remove dead code and unused definitions")
+var sourceFile = flag.String("source", "", "Reject a `.cfc` program that is out of date with this source, defaults to the `.cf` file next to it")
+
 func main() {
-	content, err := ioutil.ReadFile("./config/filesystem.cf")
+	flag.Parse()
+
+	filename := "./config/main.cf"
+	loader := NewLoader()
+	var program *compiler.Program
+
+	if flag.NArg() > 0 {
+		filename = flag.Arg(0)
+	}
+
+	if filepath.Ext(filename) == ".cfc" {
+		program = load(loader, filename)
+	} else {
+		program = compile(loader, filename)
+	}
+
+	if *disassembleOnly {
+		loadSources(loader, program)
+		disassemble(loader, filename, program)
+		return
+	}
+
+	if *outputFile != "" {
+		file, err := os.Create(*outputFile)
+
+		if err == nil {
+			err = compiler.Encode(file, program)
+			file.Close()
+		}
+
+		if err != nil {
+			report(loader, filename, err)
+		}
+
+		return
+	}
+
+	machine := vm.NewVm(program)
+	setBuiltins(machine)
+
+	err := machine.Run()
 
 	if err != nil {
-		panic(err)
+		report(loader, filename, err)
 	}
+}
 
-	lexer := NewLexer(string(content))
-	tokens, err := lexer.Lex()
+func disassemble(loader *Loader, filename string, program *compiler.Program) {
+	disassembler := compiler.NewDisassembler(program)
+	disassembler.Sources = loader.Sources
+
+	if err := disassembler.Disassemble(os.Stdout); err != nil {
+		report(loader, filename, err)
+	}
+}
+
+// loadSources reads the sources a precompiled program refers to, when they're
+// still around, so the disassembly can show them
+func loadSources(loader *Loader, program *compiler.Program) {
+	for _, line := range program.Lines {
+		if _, exist := loader.Sources[line.Location.File]; exist || line.Location.File == "" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(line.Location.File)
+		loader.Sources[line.Location.File] = string(content)
+
+		if err != nil {
+			loader.Sources[line.Location.File] = ""
+		}
+	}
+}
+
+func load(loader *Loader, filename string) *compiler.Program {
+	file, err := os.Open(filename)
 
 	if err != nil {
-		panic(err)
+		report(loader, filename, err)
 	}
 
-	fmt.Println("LEXER\n---")
+	defer file.Close()
+	program, err := compiler.Decode(file)
 
-	for _, token := range tokens {
-		fmt.Println(token)
+	if err != nil {
+		report(loader, filename, err)
 	}
 
-	fmt.Println("\nPARSER\n---")
+	source := *sourceFile
+
+	if source == "" {
+		source = strings.TrimSuffix(filename, ".cfc") + ".cf"
+
+		if _, err := os.Stat(source); err != nil {
+			return program
+		}
+	}
+
+	if err := loader.CheckSource(program, source); err != nil {
+		report(loader, filename, err)
+	}
+
+	return program
+}
+
+func compile(loader *Loader, filename string) *compiler.Program {
+	content, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		report(loader, filename, err)
+	}
+
+	loader.Sources[filename] = string(content)
+	lexer := NewFileLexer(filename, string(content))
+	tokens, err := lexer.Lex()
+
+	if err != nil {
+		report(loader, filename, err)
+	}
 
 	parser := NewParser(tokens)
 	source, err := parser.Parse()
 
 	if err != nil {
-		panic(err)
+		report(loader, filename, err)
+	}
+
+	err = loader.Resolve(source, filename)
+
+	if err != nil {
+		report(loader, filename, err)
 	}
 
-	spew.Config.DisablePointerAddresses = true
-	spew.Dump(source.Block.Body)
+	resolver := resolver.NewResolver(source)
+	resolver.WarnShadowing = *warnShadowing
+	declareNames(resolver)
 
-	fmt.Println("\nCOMPILER\n---")
+	err = resolver.Resolve()
+	render(loader, filename, resolver.Warnings())
+
+	if err != nil {
+		report(loader, filename, err)
+	}
+
+	checker := checker.NewChecker(source)
+	declareBuiltins(checker)
+
+	err = checker.Check()
+
+	if err != nil {
+		report(loader, filename, err)
+	}
 
 	compiler := compiler.NewCompiler(source)
-	instructions, err := compiler.Compile()
+	compiler.Optimize = *optimize
+	program, err := compiler.Compile()
 
 	if err != nil {
-		panic(err)
+		report(loader, filename, err)
+	}
+
+	program.SourceHash = loader.Hash()
+	return program
+}
+
+func report(loader *Loader, filename string, err error) {
+	render(loader, filename, diag.From(err))
+	os.Exit(1)
+}
+
+func render(loader *Loader, filename string, diagnostics diag.Diagnostics) {
+	if len(diagnostics) == 0 {
+		return
 	}
 
-	for _, instruction := range instructions {
-		data, _ := json.Marshal(instruction)
-		fmt.Println(reflect.TypeOf(instruction).Elem().Name() + " " + string(data))
+	if *jsonOutput {
+		data, _ := diagnostics.JSON()
+		fmt.Println(string(data))
+		return
 	}
 
-	fmt.Println("\nRUN\n---")
+	stat, _ := os.Stderr.Stat()
+	renderer := diag.NewRenderer(filename, stat != nil && stat.Mode() & os.ModeCharDevice != 0)
+	renderer.Sources = loader.Sources
+	renderer.Render(os.Stderr, diagnostics)
+}
+
+func declareNames(nameResolver *resolver.Resolver) {
+	nameResolver.Declare("writeln")
+	nameResolver.Declare("array_add")
+}
+
+func declareBuiltins(typeChecker *checker.Checker) {
+	typeChecker.Declare("writeln", checker.FunctionOf(checker.Unknown))
+	typeChecker.Declare("array_add", checker.FunctionOf(checker.ArrayOf(checker.Generic("T")), checker.Generic("T")))
+}
 
-	machine := vm.NewVm(instructions)
+func setBuiltins(machine *vm.VirtualMachine) {
 	machine.Set("writeln", &vm.Value{
 		Type: &vm.Type{Id: vm.FunctionType},
 		Mutable: false,
@@ -74,15 +234,47 @@ func main() {
 		Mutable: false,
 		Value: &vm.Function{
 			Name: "add",
-			Func: func(values []*vm.Value) {
+			ErrorFunc: func(values []*vm.Value) error {
+				elemType := &values[0].Type.GenericParams[0]
+
+				if values[0].Frozen() {
+					return fmt.Errorf("Cannot modify frozen %s", values[0].Type.FullName())
+				} else if !values[1].Type.AssignableTo(elemType) {
+					return fmt.Errorf("Cannot add %s to %s", values[1].Type.FullName(), values[0].Type.FullName())
+				}
+
 				values[0].Value.(*vm.Array).Add(values[1])
+				return nil
 			},
 		},
 	})
+	machine.RegisterAnnotation("env", func(annotation *vm.Annotation, name string, value *vm.Value) (*vm.Value, error) {
+		if len(annotation.Args) != 1 {
+			return nil, fmt.Errorf("Annotation `@env` on `%s` expects a variable name", name)
+		}
 
-	err = machine.Run()
+		if env, exist := os.LookupEnv(fmt.Sprint(annotation.Args[0])); exist {
+			return &vm.Value{
+				Type: &vm.Type{Id: vm.StringType, Name: "string"},
+				Value: env,
+			}, nil
+		}
 
-	if err != nil {
-		panic(err)
-	}
+		return nil, nil
+	})
+	machine.RegisterAnnotation("deprecated", func(annotation *vm.Annotation, name string, value *vm.Value) (*vm.Value, error) {
+		if value == nil || value.IsNull() {
+			return nil, nil
+		}
+
+		message := fmt.Sprintf("Warning: `%s` is deprecated", name)
+
+		if len(annotation.Args) > 0 {
+			message += fmt.Sprintf(", %v", annotation.Args[0])
+		}
+
+		fmt.Fprintln(os.Stderr, message)
+
+		return nil, nil
+	})
 }
@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"errors"
 	"strings"
 	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
 	"dmeijboom/config/tokens"
 )
 
@@ -12,6 +12,7 @@ type Parser struct {
 	tokens []tokens.Token
 	scope *Scope
 	index int
+	errors diag.Diagnostics
 }
 
 func NewParser(tokens []tokens.Token) *Parser {
@@ -56,17 +57,50 @@ func (parser *Parser) accept(kind tokens.TokenKind, value ...interface{}) bool {
 func (parser *Parser) expect(kind tokens.TokenKind, value ...interface{}) *tokens.Token {
 	token := parser.tok()
 
-	if !parser.hasTokens() {
-		panic(fmt.Errorf("SyntaxError: Unexpected EOF"))
-	} else if !parser.accept(kind) {
-		panic(fmt.Errorf("SyntaxError: expecting type `%s` not %s", kind, token.String()))
-	} else if len(value) > 0 && token.Value != value[0] {
-		panic(fmt.Errorf("SyntaxError: expecting token value %#v not %#v", value[0], token.Value))
+	if !parser.accept(kind, value...) {
+		panic(parser.unexpected(describeKind(kind, value...)))
 	}
 
 	return token
 }
 
+// syntaxError is raised with panic while parsing a statement, parseStmt
+// recovers it and reports it as a diagnostic
+type syntaxError struct {
+	message string
+}
+
+func (err *syntaxError) Error() string {
+	return err.message
+}
+
+func syntaxErrorf(format string, args ...interface{}) *syntaxError {
+	return &syntaxError{message: fmt.Sprintf(format, args...)}
+}
+
+func (parser *Parser) unexpected(expected string) *syntaxError {
+	return syntaxErrorf("expected %s, found %s", expected, describeToken(parser.tok()))
+}
+
+// endStmt accepts the end of a statement, which is either an EndStmt or the
+// `}` of the block the statement is the last one of, like in `if x { a() }`
+func (parser *Parser) endStmt() bool {
+	if parser.accept(tokens.EndStmt) {
+		return true
+	} else if parser.accept(tokens.RBracket) {
+		parser.pushBack()
+		return true
+	}
+
+	return false
+}
+
+func (parser *Parser) expectEndStmt() {
+	if !parser.endStmt() {
+		panic(parser.unexpected("EndStmt"))
+	}
+}
+
 func (parser *Parser) tok() *tokens.Token {
 	if !parser.hasTokens() {
 		return nil
@@ -81,16 +115,6 @@ func (parser *Parser) next() *tokens.Token {
 	return &token
 }
 
-func (parser *Parser) wrapError(str string) error {
-	token := parser.tok()
-
-	if !parser.hasTokens() {
-		token = &parser.tokens[len(parser.tokens)-1]
-	}
-
-	return fmt.Errorf("%s at %d:%d", str, token.Loc.Line, token.Loc.Column)
-}
-
 func (parser *Parser) ident() *ast.Ident {
 	tok := parser.expect(tokens.Ident)
 
@@ -100,41 +124,68 @@ func (parser *Parser) ident() *ast.Ident {
 	}
 }
 
-func (parser *Parser) section() {
-	ident := parser.ident()
-	parser.expect(tokens.LBracket)
+func (parser *Parser) block() *ast.Block {
+	loc := parser.expect(tokens.LBracket).Loc
 	parser.openScope()
-	loc := parser.tok().Loc
+
+	// The input can end right after the bracket
+	if token := parser.tok(); token != nil {
+		loc = token.Loc
+	}
 	parser.parseGlobal()
 	body := parser.closeScope()
 	parser.expect(tokens.RBracket)
-	parser.expect(tokens.EndStmt)
+
+	return &ast.Block{Body: body, Location: loc}
+}
+
+func (parser *Parser) section() {
+	ident := parser.ident()
+	var sectionType *ast.Type
+
+	if parser.accept(tokens.Colon) {
+		sectionType = parser.parseType()
+	}
+
+	block := parser.block()
+	parser.expectEndStmt()
 	
 	parser.scope.Add(&ast.Section{
 		Name: ident,
-		Block: &ast.Block{Body: body, Location: loc},
+		Type: sectionType,
+		Block: block,
 	})
 }
 
 func (parser *Parser) objectdef() *ast.Type {
-	parser.expect(tokens.Ident, "object")
-	parser.pushBack()
-
 	name := parser.ident()
 	fields := []ast.Field{}
 
-	parser.accept(tokens.LBracket)
+	var base *ast.Type
 
-	for parser.accept(tokens.Ident) {
+	if name.Value != "object" {
+		base = &ast.Type{Name: name}
+		name = &ast.Ident{
+			Value: "object",
+			Location: name.Loc(),
+		}
+	}
+
+	parser.expect(tokens.LBracket)
+
+	for parser.accept(tokens.Ident) || parser.accept(tokens.At) || parser.accept(tokens.DocComment) {
 		parser.pushBack()
+		doc, annotations := parser.docAndAnnotations()
 		fieldName := parser.ident()
 		parser.expect(tokens.Colon)
 		fieldType := parser.parseType()
-		parser.expect(tokens.EndStmt)
+		parser.expectEndStmt()
 
 		fields = append(fields, ast.Field{
 			Name: fieldName,
 			Type: fieldType,
+			Annotations: annotations,
+			Doc: doc,
 		})
 	}
 
@@ -142,10 +193,69 @@ func (parser *Parser) objectdef() *ast.Type {
 
 	return &ast.Type{
 		Name: name,
+		Base: base,
 		Fields: fields,
 	}
 }
 
+func (parser *Parser) annotations() []ast.Annotation {
+	annotations := []ast.Annotation{}
+	at := parser.tok()
+
+	for parser.accept(tokens.At) {
+		annotation := ast.Annotation{
+			Name: parser.ident(),
+			Args: []*ast.Literal{},
+			Location: at.Loc,
+		}
+
+		if parser.accept(tokens.LParent) && !parser.accept(tokens.RParent) {
+			for {
+				arg := parser.literal()
+
+				if arg == nil {
+					panic(syntaxErrorf("Annotation arguments must be literals"))
+				}
+
+				annotation.Args = append(annotation.Args, arg)
+
+				if !parser.accept(tokens.Comma) {
+					break
+				}
+			}
+
+			parser.expect(tokens.RParent)
+		}
+
+		parser.accept(tokens.EndStmt)
+		annotations = append(annotations, annotation)
+		at = parser.tok()
+	}
+
+	return annotations
+}
+
+func (parser *Parser) docComment() string {
+	lines := []string{}
+
+	for parser.accept(tokens.DocComment) {
+		lines = append(lines, parser.tokens[parser.index-1].Value.(string))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (parser *Parser) docAndAnnotations() (string, []ast.Annotation) {
+	doc := parser.docComment()
+	annotations := parser.annotations()
+
+	if len(annotations) > 0 && doc == "" {
+		doc = parser.docComment()
+	}
+
+	return doc, annotations
+}
+
 func (parser *Parser) parseType() *ast.Type {
 	array := false
 
@@ -157,7 +267,7 @@ func (parser *Parser) parseType() *ast.Type {
 	name := parser.ident()
 
 	if name.Value == "object" {
-		panic(errors.New("SyntaxError: Cannot use object type outside typedef"))
+		panic(syntaxErrorf("Cannot use object type outside typedef"))
 	}
 
 	return &ast.Type{
@@ -167,47 +277,91 @@ func (parser *Parser) parseType() *ast.Type {
 	}
 }
 
-func (parser *Parser) init() *ast.Initialize {
-	new := parser.expect(tokens.Keyword, "new")
+func (parser *Parser) initBody(init *ast.Initialize) {
 	parser.expect(tokens.LBracket)
 
-	fields := []ast.InitializeField{}
-
 	for {
-		if !parser.accept(tokens.Ident) {
-			break
-		}
+		if parser.accept(tokens.Spread) {
+			if len(init.Fields) > 0 {
+				panic(syntaxErrorf("Spread must come before the initializer fields"))
+			}
 
-		parser.pushBack()
-		name := parser.ident()
+			spread := parser.tokens[parser.index-1]
+			init.Spreads = append(init.Spreads, ast.Spread{
+				Value: parser.expr(),
+				Location: spread.Loc,
+			})
+		} else if parser.accept(tokens.Ident) {
+			parser.pushBack()
+			name := parser.ident()
 
-		parser.expect(tokens.Equals)
-		expr := parser.expr()
-		parser.expect(tokens.EndStmt)
+			parser.expect(tokens.Equals)
+			expr := parser.expr()
 
-		fields = append(fields, ast.InitializeField{
-			Name: name,
-			Value: expr,
-			Location: name.Loc(),
-		})
+			init.Fields = append(init.Fields, ast.InitializeField{
+				Name: name,
+				Value: expr,
+				Location: name.Loc(),
+			})
+		} else {
+			break
+		}
+
+		if !parser.accept(tokens.Comma) && !parser.accept(tokens.EndStmt) {
+			break
+		}
 	}
 
 	parser.expect(tokens.RBracket)
+}
 
-	return &ast.Initialize{
-		Fields: fields,
+func (parser *Parser) init() *ast.Initialize {
+	new := parser.expect(tokens.Keyword, "new")
+	init := &ast.Initialize{
+		Spreads: []ast.Spread{},
+		Fields: []ast.InitializeField{},
 		Location: new.Loc,
 	}
+
+	if parser.accept(tokens.Ident) {
+		parser.pushBack()
+		init.Type = &ast.Type{Name: parser.ident()}
+	}
+
+	parser.initBody(init)
+
+	return init
 }
 
-func (parser *Parser) member() ast.Expr {
-	object := parser.ident()
-	parser.expect(tokens.Interpunct)
+func (parser *Parser) with(base ast.Expr) *ast.Initialize {
+	with := parser.expect(tokens.Keyword, "with")
+	init := &ast.Initialize{
+		Spreads: []ast.Spread{ast.Spread{
+			Value: base,
+			Location: base.Loc(),
+		}},
+		Fields: []ast.InitializeField{},
+		Location: with.Loc,
+	}
+
+	parser.initBody(init)
+
+	return init
+}
+
+func (parser *Parser) member(object ast.Expr) ast.Expr {
+	optional := parser.accept(tokens.OptionalChain)
+
+	if !optional {
+		parser.expect(tokens.Interpunct)
+	}
+
 	field := parser.ident()
 
 	return &ast.Member{
 		Object: object,
 		Field: field,
+		Optional: optional,
 		Location: object.Loc(),
 	}
 }
@@ -217,8 +371,14 @@ func (parser *Parser) call(callee ast.Expr) ast.Expr {
 
 	args := []ast.Expr{}
 
+	// Arguments used to be separated by whitespace only, so a comma or a
+	// newline both work
 	for !parser.accept(tokens.RParent) {
 		args = append(args, parser.expr())
+
+		if !parser.accept(tokens.Comma) {
+			parser.accept(tokens.EndStmt)
+		}
 	}
 
 	return &ast.Call{
@@ -229,109 +389,493 @@ func (parser *Parser) call(callee ast.Expr) ast.Expr {
 }
 
 func (parser *Parser) expr() ast.Expr {
+	if parser.accept(tokens.Keyword, "if") {
+		parser.pushBack()
+		return parser.conditional()
+	}
+
+	return parser.equality()
+}
+
+func (parser *Parser) conditional() ast.Expr {
+	if_ := parser.expect(tokens.Keyword, "if")
+	cond := parser.expr()
+	parser.expect(tokens.Keyword, "then")
+	then := parser.expr()
+	parser.expect(tokens.Keyword, "else")
+
+	return &ast.Conditional{
+		Cond: cond,
+		Then: then,
+		Else: parser.expr(),
+		Location: if_.Loc,
+	}
+}
+
+func (parser *Parser) equality() ast.Expr {
+	expr := parser.coalesce()
+
+	for {
+		var op ast.Operator
+
+		if parser.accept(tokens.Eq) {
+			op = ast.Equal
+		} else if parser.accept(tokens.NotEq) {
+			op = ast.NotEqual
+		} else {
+			return expr
+		}
+
+		expr = &ast.Binary{
+			Op: op,
+			Left: expr,
+			Right: parser.coalesce(),
+			Location: expr.Loc(),
+		}
+	}
+}
+
+func (parser *Parser) coalesce() ast.Expr {
+	expr := parser.isNull()
+
+	for parser.accept(tokens.Coalesce) {
+		expr = &ast.Binary{
+			Op: ast.Coalesce,
+			Left: expr,
+			Right: parser.isNull(),
+			Location: expr.Loc(),
+		}
+	}
+
+	return expr
+}
+
+func (parser *Parser) isNull() ast.Expr {
+	expr := parser.unary()
+
+	if parser.accept(tokens.Keyword, "is") {
+		parser.expect(tokens.Null)
+
+		return &ast.IsNull{
+			Value: expr,
+			Location: expr.Loc(),
+		}
+	}
+
+	return expr
+}
+
+func (parser *Parser) unary() ast.Expr {
 	token := parser.tok()
 
-	if parser.accept(tokens.Keyword, "new") {
-		parser.pushBack()
-		return parser.init()
-	} else if parser.accept(tokens.Ident) {
-		var expr ast.Expr
+	if parser.accept(tokens.Not) {
+		return &ast.Not{
+			Value: parser.unary(),
+			Location: token.Loc,
+		}
+	}
+
+	return parser.postfix()
+}
 
-		if parser.accept(tokens.Interpunct) {
+func (parser *Parser) postfix() ast.Expr {
+	expr := parser.primary()
+
+	for {
+		if parser.accept(tokens.Interpunct) || parser.accept(tokens.OptionalChain) {
 			parser.pushBack()
+			expr = parser.member(expr)
+		} else if parser.accept(tokens.LParent) {
 			parser.pushBack()
-			expr = parser.member()
-		} else {
+			expr = parser.call(expr)
+		} else if parser.accept(tokens.Keyword, "with") {
 			parser.pushBack()
-			expr = parser.ident()
+			expr = parser.with(expr)
+		} else {
+			return expr
 		}
+	}
+}
 
-		if parser.accept(tokens.LParent) {
-			parser.pushBack()
-			return parser.call(expr)
+func (parser *Parser) loopVars() (*ast.Ident, *ast.Ident) {
+	value := parser.ident()
+
+	if parser.accept(tokens.Comma) {
+		return value, parser.ident()
+	}
+
+	return nil, value
+}
+
+func (parser *Parser) array() ast.Expr {
+	token := parser.expect(tokens.LSqrBracket)
+	elements := []ast.Expr{}
+
+	if parser.accept(tokens.RSqrBracket) {
+		return &ast.Array{Elements: elements, Location: token.Loc}
+	}
+
+	elements = append(elements, parser.expr())
+
+	if parser.accept(tokens.Keyword, "for") {
+		comprehension := &ast.Comprehension{
+			Result: elements[0],
+			Location: token.Loc,
+		}
+
+		comprehension.Key, comprehension.Value = parser.loopVars()
+		parser.expect(tokens.Keyword, "in")
+		comprehension.Collection = parser.expr()
+
+		if parser.accept(tokens.Keyword, "if") {
+			comprehension.Filter = parser.expr()
 		}
 
-		return expr
+		parser.expect(tokens.RSqrBracket)
+		return comprehension
+	}
+
+	for parser.accept(tokens.Comma) {
+		elements = append(elements, parser.expr())
+	}
+
+	parser.expect(tokens.RSqrBracket)
+
+	return &ast.Array{Elements: elements, Location: token.Loc}
+}
+
+func (parser *Parser) literal() *ast.Literal {
+	token := parser.tok()
+
+	if parser.accept(tokens.Null) {
+		return &ast.Literal{
+			Type: ast.Null,
+			Location: token.Loc,
+		}
 	} else if parser.accept(tokens.String) {
 		return &ast.Literal{
 			Type: ast.String,
 			Value: token.Value,
+			Location: token.Loc,
 		}
 	} else if parser.accept(tokens.Integer) {
 		return &ast.Literal{
 			Type: ast.Integer,
 			Value: token.Value,
+			Location: token.Loc,
 		}
 	} else if parser.accept(tokens.Float) {
 		return &ast.Literal{
 			Type: ast.Float,
 			Value: token.Value,
+			Location: token.Loc,
 		}
 	} else if parser.accept(tokens.Boolean) {
 		return &ast.Literal{
 			Type: ast.Boolean,
 			Value: token.Value,
+			Location: token.Loc,
 		}
 	}
 
-	panic(fmt.Errorf("SyntaxError: unexpected %s", token))
+	return nil
 }
 
-func (parser *Parser) assign() {
-	parser.expect(tokens.Keyword, "let")
+func (parser *Parser) pattern() ast.Pattern {
+	token := parser.tok()
+
+	if literal := parser.literal(); literal != nil {
+		return &ast.LiteralPattern{Literal: literal}
+	} else if parser.accept(tokens.Ident, "_") {
+		return &ast.WildcardPattern{Location: token.Loc}
+	}
+
+	pattern := &ast.TypePattern{
+		Type: parser.parseType(),
+		Fields: []ast.FieldPattern{},
+	}
+
+	if !parser.accept(tokens.LBracket) {
+		return pattern
+	}
+
+	for parser.accept(tokens.Ident) {
+		parser.pushBack()
+		field := ast.FieldPattern{Name: parser.ident()}
+
+		if parser.accept(tokens.Equals) {
+			field.Pattern = parser.pattern()
+		}
+
+		pattern.Fields = append(pattern.Fields, field)
+
+		if !parser.accept(tokens.Comma) && !parser.accept(tokens.EndStmt) {
+			break
+		}
+	}
+
+	parser.expect(tokens.RBracket)
+
+	return pattern
+}
+
+func (parser *Parser) match() ast.Expr {
+	match := parser.expect(tokens.Keyword, "match")
+	node := &ast.Match{
+		Value: parser.expr(),
+		Arms: []ast.MatchArm{},
+		Location: match.Loc,
+	}
+
+	parser.expect(tokens.LBracket)
+
+	for !parser.accept(tokens.RBracket) {
+		pattern := parser.pattern()
+		parser.expect(tokens.FatArrow)
+
+		node.Arms = append(node.Arms, ast.MatchArm{
+			Pattern: pattern,
+			Result: parser.expr(),
+			Location: pattern.Loc(),
+		})
+
+		if !parser.accept(tokens.Comma) && !parser.accept(tokens.EndStmt) {
+			parser.expect(tokens.RBracket)
+			break
+		}
+	}
+
+	return node
+}
+
+func (parser *Parser) primary() ast.Expr {
+	if literal := parser.literal(); literal != nil {
+		return literal
+	} else if parser.accept(tokens.Keyword, "match") {
+		parser.pushBack()
+		return parser.match()
+	} else if parser.accept(tokens.LSqrBracket) {
+		parser.pushBack()
+		return parser.array()
+	} else if parser.accept(tokens.Keyword, "new") {
+		parser.pushBack()
+		return parser.init()
+	} else if parser.accept(tokens.Ident) {
+		parser.pushBack()
+		return parser.ident()
+	}
+
+	panic(parser.unexpected("an expression"))
+}
+
+func (parser *Parser) assign(doc string, annotations []ast.Annotation) {
+	keyword := parser.expect(tokens.Keyword)
 	name := parser.ident()
-	parser.expect(tokens.Colon)
-	type_ := parser.parseType()
+	isConst := keyword.Value == "const"
 
+	var type_ *ast.Type
 	var value ast.Expr
 
-	if parser.accept(tokens.Equals) {
+	if parser.accept(tokens.Colon) {
+		type_ = parser.parseType()
+
+		if parser.accept(tokens.Equals) {
+			value = parser.expr()
+		} else if isConst {
+			panic(parser.unexpected("`=` after type in const"))
+		} else if !parser.accept(tokens.EndStmt) && !parser.accept(tokens.RBracket) {
+			panic(parser.unexpected("`=` or EndStmt after type in let"))
+		} else {
+			parser.pushBack()
+		}
+	} else if parser.accept(tokens.Equals) {
 		value = parser.expr()
+	} else {
+		panic(parser.unexpected(fmt.Sprintf("`:` or `=` after name in %s", keyword.Value)))
 	}
 
-	parser.expect(tokens.EndStmt)
+	if !parser.endStmt() {
+		panic(parser.unexpected(fmt.Sprintf("EndStmt after value in %s", keyword.Value)))
+	}
+
+	if init, ok := value.(*ast.Initialize); ok && type_ != nil && !type_.Array &&
+		init.Type == nil && len(init.Spreads) == 0 {
+		init.Type = &ast.Type{Name: type_.Name}
+	}
 
 	parser.scope.Add(&ast.Assign{
+		Const: isConst,
 		Name: name,
 		Type: type_,
 		Value: value,
+		Annotations: annotations,
+		Doc: doc,
 	})
 }
 
-func (parser *Parser) typedef() {
+func (parser *Parser) typedef(doc string, annotations []ast.Annotation) {
 	parser.expect(tokens.Keyword, "type")
 	name := parser.ident()
 	parser.expect(tokens.Colon)
 
 	var typeval *ast.Type
+	index := parser.index
 
 	if parser.accept(tokens.Ident, "object") {
 		parser.pushBack()
 		typeval = parser.objectdef()
+	} else if parser.accept(tokens.Ident) && parser.accept(tokens.LBracket) {
+		parser.pushBack()
+		parser.pushBack()
+		typeval = parser.objectdef()
 	} else {
+		parser.index = index
 		typeval = parser.parseType()
 	}
 
-	parser.expect(tokens.EndStmt)
+	parser.expectEndStmt()
 
 	parser.scope.Add(&ast.Typedef{
 		Name: name,
 		Type: typeval,
+		Annotations: annotations,
+		Doc: doc,
 	})
 }
 
+func (parser *Parser) assert() {
+	assert := parser.expect(tokens.Keyword, "assert")
+	cond := parser.expr()
+	parser.expect(tokens.Comma)
+	message := parser.expr()
+	parser.expectEndStmt()
+
+	parser.scope.Add(&ast.Assert{
+		Cond: cond,
+		Message: message,
+		Location: assert.Loc,
+	})
+}
+
+func (parser *Parser) importStmt() {
+	import_ := parser.expect(tokens.Keyword, "import")
+
+	if parser.scope.parent != nil {
+		panic(syntaxErrorf("Imports are only allowed at the top level"))
+	}
+
+	path := parser.expect(tokens.String)
+	parser.expectEndStmt()
+
+	parser.scope.Add(&ast.Import{
+		Path: &ast.Literal{
+			Type: ast.String,
+			Value: path.Value,
+			Location: path.Loc,
+		},
+		Location: import_.Loc,
+	})
+}
+
+func (parser *Parser) ifStmt() *ast.If {
+	if_ := parser.expect(tokens.Keyword, "if")
+	node := &ast.If{
+		Cond: parser.expr(),
+		Then: parser.block(),
+		Location: if_.Loc,
+	}
+
+	index := parser.index
+	parser.accept(tokens.EndStmt)
+
+	if !parser.accept(tokens.Keyword, "else") {
+		parser.index = index
+		return node
+	}
+
+	if parser.accept(tokens.Keyword, "if") {
+		parser.pushBack()
+		elseIf := parser.ifStmt()
+		node.Else = &ast.Block{
+			Body: []ast.Node{elseIf},
+			Location: elseIf.Loc(),
+		}
+	} else {
+		node.Else = parser.block()
+	}
+
+	return node
+}
+
+func (parser *Parser) forStmt() *ast.For {
+	for_ := parser.expect(tokens.Keyword, "for")
+	key, value := parser.loopVars()
+	parser.expect(tokens.Keyword, "in")
+
+	return &ast.For{
+		Key: key,
+		Value: value,
+		Collection: parser.expr(),
+		Block: parser.block(),
+		Location: for_.Loc,
+	}
+}
+
 func (parser *Parser) exprStmt() {
 	expr := parser.expr()
+	isAppend := parser.accept(tokens.PlusEquals)
 
-	parser.expect(tokens.EndStmt)
+	if isAppend || parser.accept(tokens.Equals) {
+		switch target := expr.(type) {
+		case *ast.Ident:
+			break
+		case *ast.Member:
+			if target.Optional {
+				panic(syntaxErrorf("Cannot assign to an optional member"))
+			}
+			break
+		default:
+			panic(syntaxErrorf("Cannot assign to this expression"))
+		}
+
+		value := parser.expr()
+		parser.expectEndStmt()
+		parser.scope.Add(&ast.Reassign{
+			Target: expr,
+			Append: isAppend,
+			Value: value,
+			Location: expr.Loc(),
+		})
+		return
+	}
+
+	parser.expectEndStmt()
 	parser.scope.Add(&ast.ExprStmt{
 		Expr: expr,
 	})
 }
 
+func (parser *Parser) declaration() {
+	doc, annotations := parser.docAndAnnotations()
+
+	if parser.accept(tokens.Keyword, "type") {
+		parser.pushBack()
+		parser.typedef(doc, annotations)
+	} else if parser.accept(tokens.Keyword, "let") || parser.accept(tokens.Keyword, "const") {
+		parser.pushBack()
+		parser.assign(doc, annotations)
+	} else if len(annotations) > 0 {
+		panic(parser.unexpected("`type`, `let` or `const` after annotations"))
+	}
+}
+
 func (parser *Parser) stmt() {
-	if parser.accept(tokens.Ident) {
-		if parser.accept(tokens.LBracket) {
+	if parser.accept(tokens.At) || parser.accept(tokens.DocComment) {
+		parser.pushBack()
+		parser.declaration()
+		return
+	} else if parser.accept(tokens.Ident) {
+		if parser.accept(tokens.LBracket) || parser.accept(tokens.Colon) {
 			parser.pushBack()
 			parser.pushBack()
 			parser.section()
@@ -345,11 +889,22 @@ func (parser *Parser) stmt() {
 		matched := true
 
 		switch token.Value.(string) {
-		case "type":
-			parser.typedef()
+		case "type", "let", "const":
+			parser.declaration()
+			break
+		case "assert":
+			parser.assert()
+			break
+		case "import":
+			parser.importStmt()
+			break
+		case "if":
+			parser.scope.Add(parser.ifStmt())
+			parser.expectEndStmt()
 			break
-		case "let":
-			parser.assign()
+		case "for":
+			parser.scope.Add(parser.forStmt())
+			parser.expectEndStmt()
 			break
 		default:
 			matched = false
@@ -370,28 +925,98 @@ func (parser *Parser) parseGlobal() {
 			parser.accept(tokens.RBracket) {
 			parser.pushBack()
 			return
+		} else if parser.accept(tokens.EndStmt) {
+			continue
 		}
 
-		parser.stmt()
+		parser.parseStmt()
 	}
 }
 
-func (parser *Parser) Parse() (source *ast.Source, err error) {
+func (parser *Parser) parseStmt() {
+	start := parser.index
+	scope := parser.scope
+
 	defer func() {
 		if r := recover(); r != nil {
-			if _, ok := r.(error); ok && strings.Contains(r.(error).Error(), "SyntaxError:") {
-				err = parser.wrapError(r.(error).Error())
+			if err, isSyntaxError := r.(*syntaxError); isSyntaxError {
+				parser.errors = append(parser.errors, diag.New(
+					diag.SyntaxError,
+					diag.At(parser.errorLoc()),
+					"%s", err.message,
+				))
+				parser.scope = scope
+				parser.synchronize(start)
 			} else {
 				panic(r)
 			}
 		}
 	}()
 
+	parser.stmt()
+}
+
+func (parser *Parser) errorLoc() *tokens.Location {
+	if !parser.hasTokens() {
+		if len(parser.tokens) == 0 {
+			return &tokens.Location{Line: 1, Column: 0}
+		}
+
+		return parser.tokens[len(parser.tokens)-1].Loc
+	}
+
+	return parser.tok().Loc
+}
+
+func (parser *Parser) synchronize(start int) {
+	depth := 0
+
+	for i := start; i < parser.index; i++ {
+		if parser.tokens[i].Kind == tokens.LBracket {
+			depth++
+		} else if parser.tokens[i].Kind == tokens.RBracket {
+			depth--
+		}
+	}
+
+	for parser.hasTokens() {
+		switch parser.tok().Kind {
+		case tokens.LBracket:
+			depth++
+			break
+		case tokens.RBracket:
+			if depth > 0 {
+				depth--
+				break
+			} else if parser.scope.parent != nil {
+				return
+			}
+
+			parser.index++
+			parser.accept(tokens.EndStmt)
+			return
+		case tokens.EndStmt:
+			if depth <= 0 {
+				parser.index++
+				return
+			}
+			break
+		}
+
+		parser.index++
+	}
+}
+
+func (parser *Parser) Parse() (*ast.Source, error) {
 	parser.parseGlobal()
-	source = &ast.Source{Block: &ast.Block{
+	source := &ast.Source{Block: &ast.Block{
 		Body: parser.closeScope(),
 		Location: &tokens.Location{Line: 0, Column: 0},
 	}}
 
-	return
+	if len(parser.errors) > 0 {
+		return source, parser.errors
+	}
+
+	return source, nil
 }
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"testing"
 	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,8 +26,23 @@ func parseCmpNode(t *testing.T, a ast.Node, b ast.Node) {
 		parseCmpNode(t, node_a.Name, node_b.Name)
 		parseCmpNode(t, node_a.Value, node_b.Value)
 		break
+	case *ast.Spread:
+		node_b := b.(*ast.Spread)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		break
 	case *ast.Initialize:
 		node_b := b.(*ast.Initialize)
+
+		if node_b.Type != nil {
+			parseCmpNode(t, node_a.Type, node_b.Type)
+		}
+
+		assert.Equal(t, len(node_b.Spreads), len(node_a.Spreads), "Initialize spread count doesn't match")
+
+		for i := 0; i < len(node_a.Spreads) && i < len(node_b.Spreads); i++ {
+			parseCmpNode(t, &node_a.Spreads[i], &node_b.Spreads[i])
+		}
+
 		assert.Equal(t, len(node_b.Fields), len(node_a.Fields), "Initialize field count doesn't match")
 
 		for i := 0; i < len(node_a.Fields); i++ {
@@ -45,6 +61,10 @@ func parseCmpNode(t *testing.T, a ast.Node, b ast.Node) {
 		node_b := b.(*ast.Section)
 		parseCmpNode(t, node_a.Name, node_b.Name)
 		parseCmpNode(t, node_a.Block, node_b.Block)
+
+		if assert.Equal(t, node_a.Type == nil, node_b.Type == nil, "Section type doesn't match") && node_a.Type != nil {
+			parseCmpNode(t, node_a.Type, node_b.Type)
+		}
 		break
 	case *ast.Typedef:
 		node_b := b.(*ast.Typedef)
@@ -63,6 +83,11 @@ func parseCmpNode(t *testing.T, a ast.Node, b ast.Node) {
 	case *ast.Type:
 		node_b := b.(*ast.Type)
 		parseCmpNode(t, node_a.Name, node_b.Name)
+
+		if node_a.Base != nil || node_b.Base != nil {
+			parseCmpNode(t, node_a.Base, node_b.Base)
+		}
+
 		assert.Equal(t, node_b.Array, node_a.Array, "Type should be an array")
 		assert.Equal(t, node_b.Optional, node_a.Optional, "Type should be optional")
 
@@ -80,7 +105,12 @@ func parseCmpNode(t *testing.T, a ast.Node, b ast.Node) {
 	case *ast.Assign:
 		node_b := b.(*ast.Assign)
 		parseCmpNode(t, node_a.Name, node_b.Name)
-		parseCmpNode(t, node_a.Type, node_b.Type)
+
+		if assert.Equal(t, node_b.Type == nil, node_a.Type == nil, "Assign type should be both nil or both not nil") &&
+			node_a.Type != nil {
+			parseCmpNode(t, node_a.Type, node_b.Type)
+		}
+
 		parseCmpNode(t, node_a.Value, node_b.Value)
 		break
 	case *ast.ExprStmt:
@@ -99,9 +129,87 @@ func parseCmpNode(t *testing.T, a ast.Node, b ast.Node) {
 		break
 	case *ast.Member:
 		node_b := b.(*ast.Member)
+		assert.Equal(t, node_b.Optional, node_a.Optional, "Member should be optional")
 		parseCmpNode(t, node_a.Object, node_b.Object)
 		parseCmpNode(t, node_a.Field, node_b.Field)
 		break
+	case *ast.Binary:
+		node_b := b.(*ast.Binary)
+		assert.Equal(t, node_b.Op, node_a.Op, "Binary operator doesn't match")
+		parseCmpNode(t, node_a.Left, node_b.Left)
+		parseCmpNode(t, node_a.Right, node_b.Right)
+		break
+	case *ast.IsNull:
+		node_b := b.(*ast.IsNull)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		break
+	case *ast.Not:
+		node_b := b.(*ast.Not)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		break
+	case *ast.Conditional:
+		node_b := b.(*ast.Conditional)
+		parseCmpNode(t, node_a.Cond, node_b.Cond)
+		parseCmpNode(t, node_a.Then, node_b.Then)
+		parseCmpNode(t, node_a.Else, node_b.Else)
+		break
+	case *ast.If:
+		node_b := b.(*ast.If)
+		parseCmpNode(t, node_a.Cond, node_b.Cond)
+		parseCmpNode(t, node_a.Then, node_b.Then)
+
+		if assert.Equal(t, node_b.Else == nil, node_a.Else == nil, "If else-blocks should be both nil or both not nil") &&
+			node_a.Else != nil {
+			parseCmpNode(t, node_a.Else, node_b.Else)
+		}
+		break
+	case *ast.Array:
+		node_b := b.(*ast.Array)
+		assert.Equal(t, len(node_b.Elements), len(node_a.Elements), "Array element count doesn't match")
+
+		for i := 0; i < len(node_a.Elements) && i < len(node_b.Elements); i++ {
+			parseCmpNode(t, node_a.Elements[i], node_b.Elements[i])
+		}
+		break
+	case *ast.Comprehension:
+		node_b := b.(*ast.Comprehension)
+		parseCmpNode(t, node_a.Result, node_b.Result)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		parseCmpNode(t, node_a.Collection, node_b.Collection)
+
+		if node_a.Key != nil || node_b.Key != nil {
+			parseCmpNode(t, node_a.Key, node_b.Key)
+		}
+
+		if node_a.Filter != nil || node_b.Filter != nil {
+			parseCmpNode(t, node_a.Filter, node_b.Filter)
+		}
+		break
+	case *ast.For:
+		node_b := b.(*ast.For)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		parseCmpNode(t, node_a.Collection, node_b.Collection)
+		parseCmpNode(t, node_a.Block, node_b.Block)
+
+		if node_a.Key != nil || node_b.Key != nil {
+			parseCmpNode(t, node_a.Key, node_b.Key)
+		}
+		break
+	case *ast.Match:
+		node_b := b.(*ast.Match)
+		parseCmpNode(t, node_a.Value, node_b.Value)
+		assert.Equal(t, len(node_b.Arms), len(node_a.Arms), "Match arm count doesn't match")
+
+		for i := 0; i < len(node_a.Arms) && i < len(node_b.Arms); i++ {
+			assert.Equal(t, node_b.Arms[i].Pattern, node_a.Arms[i].Pattern, "Match pattern doesn't match")
+			parseCmpNode(t, node_a.Arms[i].Result, node_b.Arms[i].Result)
+		}
+		break
+	case *ast.Assert:
+		node_b := b.(*ast.Assert)
+		parseCmpNode(t, node_a.Cond, node_b.Cond)
+		parseCmpNode(t, node_a.Message, node_b.Message)
+		break
 	default:
 		panic(node_a)
 	}
@@ -138,11 +246,24 @@ func TestSection(t *testing.T) {
 
 	}
 	example {}`, []ast.Node{
-		&ast.Section{&ast.Ident{"testSection", nil}, &ast.Block{[]ast.Node{}, nil}},
-		&ast.Section{&ast.Ident{"example", nil}, &ast.Block{[]ast.Node{}, nil}},
+		&ast.Section{&ast.Ident{"testSection", nil}, &ast.Block{[]ast.Node{}, nil}, nil},
+		&ast.Section{&ast.Ident{"example", nil}, &ast.Block{[]ast.Node{}, nil}, nil},
 	})
 }
 
+func TestTypedSection(t *testing.T) {
+	parseCmp(t, `server: Server {
+		let port = 80
+	}`, []ast.Node{&ast.Section{
+		Name: &ast.Ident{Value: "server"},
+		Type: &ast.Type{Name: &ast.Ident{Value: "Server"}},
+		Block: &ast.Block{Body: []ast.Node{&ast.Assign{
+			Name: &ast.Ident{Value: "port"},
+			Value: &ast.Literal{Type: ast.Integer, Value: 80},
+		}}},
+	}})
+}
+
 func TestTypedef(t *testing.T) {
 	parseCmp(t, `type Test: int`, []ast.Node{
 		&ast.Typedef{
@@ -235,6 +356,17 @@ func TestCall(t *testing.T) {
 			},
 		},
 	})
+
+	for _, input := range []string{"writeln(a, b)", "writeln(a b)", "writeln(\n\ta\n\tb\n)"} {
+		parseCmp(t, input, []ast.Node{
+			&ast.ExprStmt{
+				Expr: &ast.Call{
+					Args: []ast.Expr{&ast.Ident{"a", nil}, &ast.Ident{"b", nil}},
+					Callee: &ast.Ident{"writeln", nil},
+				},
+			},
+		})
+	}
 }
 
 func TestInitializer(t *testing.T) {
@@ -314,3 +446,485 @@ func TestMember(t *testing.T) {
 		},
 	})
 }
+
+func TestAssert(t *testing.T) {
+	parseCmp(t, `assert mounted, "root must be mounted"`, []ast.Node{
+		&ast.Assert{
+			Cond: &ast.Ident{Value: "mounted"},
+			Message: &ast.Literal{Type: ast.String, Value: "root must be mounted"},
+		},
+	})
+
+	_, errLexer, errParser := tokenizeAndParse(`assert mounted`)
+
+	assert.Equal(t, nil, errLexer, "Lexer shouldn't fail")
+	assert.NotEqual(t, nil, errParser, "Assert requires a message")
+}
+
+func TestSpread(t *testing.T) {
+	expected := &ast.Initialize{
+		Spreads: []ast.Spread{
+			ast.Spread{Value: &ast.Ident{Value: "base"}},
+		},
+		Fields: []ast.InitializeField{
+			ast.InitializeField{
+				Name: &ast.Ident{Value: "path"},
+				Value: &ast.Literal{Type: ast.String, Value: "/home"},
+			},
+		},
+	}
+
+	parseCmp(t, `let home: Filesystem = new { ...base, path = "/home" }`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "home"},
+			Type: &ast.Type{Name: &ast.Ident{Value: "Filesystem"}},
+			Value: expected,
+		},
+	})
+
+	parseCmp(t, `let home: Filesystem = base with { path = "/home" }`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "home"},
+			Type: &ast.Type{Name: &ast.Ident{Value: "Filesystem"}},
+			Value: expected,
+		},
+	})
+
+	_, errLexer, errParser := tokenizeAndParse(`let home: Filesystem = new { path = "/home", ...base }`)
+
+	assert.Equal(t, nil, errLexer, "Lexer shouldn't fail")
+	assert.NotEqual(t, nil, errParser, "Spread must come before the fields")
+}
+
+func TestTypedefExtends(t *testing.T) {
+	parseCmp(t, `type EncryptedFs: Filesystem {
+			keyfile: string
+		}`, []ast.Node{&ast.Typedef{
+			Name: &ast.Ident{Value: "EncryptedFs"},
+			Type: &ast.Type{
+				Name: &ast.Ident{Value: "object"},
+				Base: &ast.Type{Name: &ast.Ident{Value: "Filesystem"}},
+				Fields: []ast.Field{
+					ast.Field{
+						Name: &ast.Ident{Value: "keyfile"},
+						Type: &ast.Type{Name: &ast.Ident{Value: "string"}},
+					},
+				},
+			},
+		},
+	})
+
+	parseCmp(t, `type Paths: []string`, []ast.Node{&ast.Typedef{
+			Name: &ast.Ident{Value: "Paths"},
+			Type: &ast.Type{Name: &ast.Ident{Value: "string"}, Array: true},
+		},
+	})
+}
+
+func TestTypedInitializer(t *testing.T) {
+	parseCmp(t, `filesystems.add(new Filesystem { path = "/" })`, []ast.Node{
+		&ast.ExprStmt{
+			Expr: &ast.Call{
+				Args: []ast.Expr{&ast.Initialize{
+					Type: &ast.Type{Name: &ast.Ident{Value: "Filesystem"}},
+					Fields: []ast.InitializeField{
+						ast.InitializeField{
+							Name: &ast.Ident{Value: "path"},
+							Value: &ast.Literal{Type: ast.String, Value: "/"},
+						},
+					},
+				}},
+				Callee: &ast.Member{
+					Object: &ast.Ident{Value: "filesystems"},
+					Field: &ast.Ident{Value: "add"},
+				},
+			},
+		},
+	})
+
+	parseCmp(t, `let port = 8080`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "port"},
+			Value: &ast.Literal{Type: ast.Integer, Value: 8080},
+		},
+	})
+
+	_, errLexer, errParser := tokenizeAndParse(`let port`)
+
+	assert.Equal(t, nil, errLexer, "Lexer shouldn't fail")
+	assert.NotEqual(t, nil, errParser, "Let requires a type or a value")
+}
+
+func TestNullOperators(t *testing.T) {
+	parseCmp(t, `let opts = fs?.opts ?? "defaults"`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "opts"},
+			Value: &ast.Binary{
+				Op: ast.Coalesce,
+				Left: &ast.Member{
+					Object: &ast.Ident{Value: "fs"},
+					Field: &ast.Ident{Value: "opts"},
+					Optional: true,
+				},
+				Right: &ast.Literal{Type: ast.String, Value: "defaults"},
+			},
+		},
+	})
+
+	parseCmp(t, `assert server.root.opts is null, "no options"`, []ast.Node{
+		&ast.Assert{
+			Cond: &ast.IsNull{
+				Value: &ast.Member{
+					Object: &ast.Member{
+						Object: &ast.Ident{Value: "server"},
+						Field: &ast.Ident{Value: "root"},
+					},
+					Field: &ast.Ident{Value: "opts"},
+				},
+			},
+			Message: &ast.Literal{Type: ast.String, Value: "no options"},
+		},
+	})
+
+	parseCmp(t, `let opts: string? = null`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "opts"},
+			Type: &ast.Type{Name: &ast.Ident{Value: "string"}, Optional: true},
+			Value: &ast.Literal{Type: ast.Null},
+		},
+	})
+}
+
+func TestConditional(t *testing.T) {
+	parseCmp(t, `let port = if env == "prod" then 80 else 8080`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "port"},
+			Value: &ast.Conditional{
+				Cond: &ast.Binary{
+					Op: ast.Equal,
+					Left: &ast.Ident{Value: "env"},
+					Right: &ast.Literal{Type: ast.String, Value: "prod"},
+				},
+				Then: &ast.Literal{Type: ast.Integer, Value: 80},
+				Else: &ast.Literal{Type: ast.Integer, Value: 8080},
+			},
+		},
+	})
+}
+
+func TestIf(t *testing.T) {
+	assertDebug := &ast.Assert{
+		Cond: &ast.Not{Value: &ast.Ident{Value: "debug"}},
+		Message: &ast.Literal{Type: ast.String, Value: "no debug in prod"},
+	}
+
+	parseCmp(t, `if env != "dev" {
+		assert !debug, "no debug in prod"
+	}`, []ast.Node{
+		&ast.If{
+			Cond: &ast.Binary{
+				Op: ast.NotEqual,
+				Left: &ast.Ident{Value: "env"},
+				Right: &ast.Literal{Type: ast.String, Value: "dev"},
+			},
+			Then: &ast.Block{Body: []ast.Node{assertDebug}},
+		},
+	})
+
+	parseCmp(t, `if prod {
+		assert !debug, "no debug in prod"
+	} else if staging {
+	}
+	else {
+	}`, []ast.Node{
+		&ast.If{
+			Cond: &ast.Ident{Value: "prod"},
+			Then: &ast.Block{Body: []ast.Node{assertDebug}},
+			Else: &ast.Block{Body: []ast.Node{
+				&ast.If{
+					Cond: &ast.Ident{Value: "staging"},
+					Then: &ast.Block{Body: []ast.Node{}},
+					Else: &ast.Block{Body: []ast.Node{}},
+				},
+			}},
+		},
+	})
+}
+
+func TestFor(t *testing.T) {
+	parseCmp(t, `for disk in disks {
+		writeln(disk)
+	}`, []ast.Node{
+		&ast.For{
+			Value: &ast.Ident{Value: "disk"},
+			Collection: &ast.Ident{Value: "disks"},
+			Block: &ast.Block{Body: []ast.Node{
+				&ast.ExprStmt{Expr: &ast.Call{
+					Args: []ast.Expr{&ast.Ident{Value: "disk"}},
+					Callee: &ast.Ident{Value: "writeln"},
+				}},
+			}},
+		},
+	})
+
+	parseCmp(t, `for name, value in options {
+	}`, []ast.Node{
+		&ast.For{
+			Key: &ast.Ident{Value: "name"},
+			Value: &ast.Ident{Value: "value"},
+			Collection: &ast.Ident{Value: "options"},
+			Block: &ast.Block{Body: []ast.Node{}},
+		},
+	})
+}
+
+func TestArray(t *testing.T) {
+	parseCmp(t, `let paths = ["/", "/home"]`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "paths"},
+			Value: &ast.Array{Elements: []ast.Expr{
+				&ast.Literal{Type: ast.String, Value: "/"},
+				&ast.Literal{Type: ast.String, Value: "/home"},
+			}},
+		},
+	})
+
+	parseCmp(t, `let paths = [d.path for d in disks if d.ssd]`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "paths"},
+			Value: &ast.Comprehension{
+				Result: &ast.Member{
+					Object: &ast.Ident{Value: "d"},
+					Field: &ast.Ident{Value: "path"},
+				},
+				Value: &ast.Ident{Value: "d"},
+				Collection: &ast.Ident{Value: "disks"},
+				Filter: &ast.Member{
+					Object: &ast.Ident{Value: "d"},
+					Field: &ast.Ident{Value: "ssd"},
+				},
+			},
+		},
+	})
+}
+
+func TestMatch(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`let size = match fs {
+		Filesystem { path = "/", fstype } => 1
+		EncryptedFs => 2, "tmpfs" => 3
+		_ => 4
+	}`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		return
+	}
+
+	match := source.Block.Body[0].(*ast.Assign).Value.(*ast.Match)
+	parseCmpNode(t, match.Value, &ast.Ident{Value: "fs"})
+
+	if !assert.Equal(t, 4, len(match.Arms), "Match arm count doesn't match") {
+		return
+	}
+
+	destructure := match.Arms[0].Pattern.(*ast.TypePattern)
+	assert.Equal(t, "Filesystem", destructure.Type.Name.Value)
+	assert.Equal(t, 2, len(destructure.Fields))
+	assert.Equal(t, "path", destructure.Fields[0].Name.Value)
+	assert.Equal(t, "/", destructure.Fields[0].Pattern.(*ast.LiteralPattern).Literal.Value)
+	assert.Equal(t, "fstype", destructure.Fields[1].Name.Value)
+	assert.Nil(t, destructure.Fields[1].Pattern, "Field without pattern should bind")
+
+	assert.Equal(t, "EncryptedFs", match.Arms[1].Pattern.(*ast.TypePattern).Type.Name.Value)
+	assert.Equal(t, "tmpfs", match.Arms[2].Pattern.(*ast.LiteralPattern).Literal.Value)
+	assert.IsType(t, &ast.WildcardPattern{}, match.Arms[3].Pattern)
+	parseCmpNode(t, match.Arms[3].Result, &ast.Literal{Type: ast.Integer, Value: 4})
+}
+
+func TestReassign(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`const root = "/"
+	fs.path = "/boot"
+	mounts += fs`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		return
+	}
+
+	assert.True(t, source.Block.Body[0].(*ast.Assign).Const, "Assign should be constant")
+
+	member := source.Block.Body[1].(*ast.Reassign)
+	assert.False(t, member.Append)
+	parseCmpNode(t, member.Target, &ast.Member{
+		Object: &ast.Ident{Value: "fs"},
+		Field: &ast.Ident{Value: "path"},
+	})
+	parseCmpNode(t, member.Value, &ast.Literal{Type: ast.String, Value: "/boot"})
+
+	appended := source.Block.Body[2].(*ast.Reassign)
+	assert.True(t, appended.Append)
+	parseCmpNode(t, appended.Target, &ast.Ident{Value: "mounts"})
+
+	_, _, errParser = tokenizeAndParse(`fs?.path = "/"`)
+	assert.NotNil(t, errParser, "Assigning to an optional member should fail")
+}
+
+func TestImport(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`import "network.cf"
+	let port = 80`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		return
+	}
+
+	import_ := source.Block.Body[0].(*ast.Import)
+	parseCmpNode(t, import_.Path, &ast.Literal{Type: ast.String, Value: "network.cf"})
+
+	_, _, errParser = tokenizeAndParse(`network {
+		import "hosts.cf"
+	}`)
+	assert.NotNil(t, errParser, "Nested imports should fail")
+}
+
+func TestAnnotations(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`@doc("A mounted filesystem")
+	type Filesystem: object {
+		@env("ROOT_UUID") @secret
+		uuid: string
+		@deprecated("use uuid")
+		id: string?
+	}
+
+	@secret
+	let password = "hunter2"`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		return
+	}
+
+	typedef := source.Block.Body[0].(*ast.Typedef)
+	assert.Equal(t, 1, len(typedef.Annotations))
+	assert.Equal(t, "doc", typedef.Annotations[0].Name.Value)
+	parseCmpNode(t, typedef.Annotations[0].Args[0], &ast.Literal{Type: ast.String, Value: "A mounted filesystem"})
+
+	uuid := typedef.Type.Fields[0]
+	assert.Equal(t, 2, len(uuid.Annotations))
+	assert.Equal(t, "env", uuid.Annotations[0].Name.Value)
+	assert.Equal(t, "secret", uuid.Annotations[1].Name.Value)
+	assert.Equal(t, 0, len(uuid.Annotations[1].Args))
+	assert.Equal(t, "deprecated", typedef.Type.Fields[1].Annotations[0].Name.Value)
+
+	assign := source.Block.Body[1].(*ast.Assign)
+	assert.Equal(t, "secret", assign.Annotations[0].Name.Value)
+
+	_, _, errParser = tokenizeAndParse(`@secret
+	assert true, "not a declaration"`)
+	assert.NotNil(t, errParser, "Annotations without a declaration should fail")
+}
+
+func TestDocComments(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`/// A mounted filesystem
+	type Filesystem: object {
+		/// Mount point
+		/// relative to the root
+		@secret
+		path: string
+		uuid: string
+	}
+
+	@deprecated
+	/// Use the filesystem list instead
+	let fs = "/"
+
+	/// Sections don't keep their docs
+	network {}`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		return
+	}
+
+	typedef := source.Block.Body[0].(*ast.Typedef)
+	assert.Equal(t, "A mounted filesystem", typedef.Doc)
+	assert.Equal(t, "Mount point\nrelative to the root", typedef.Type.Fields[0].Doc)
+	assert.Equal(t, "secret", typedef.Type.Fields[0].Annotations[0].Name.Value)
+	assert.Equal(t, "", typedef.Type.Fields[1].Doc)
+	assert.Equal(t, "Use the filesystem list instead", source.Block.Body[1].(*ast.Assign).Doc)
+	assert.IsType(t, &ast.Section{}, source.Block.Body[2])
+}
+
+func TestSyntaxErrorRecovery(t *testing.T) {
+	source, errLexer, errParser := tokenizeAndParse(`let a: int 5
+	let b = 2
+	server {
+		let port = = 80
+		let host = "localhost"
+	}
+	let c = new { path = }
+	let d = 4`)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.IsType(t, diag.Diagnostics{}, errParser, "Parser should return all syntax errors") {
+		return
+	}
+
+	errors := errParser.(diag.Diagnostics)
+
+	if assert.Equal(t, 3, len(errors), "Syntax error count doesn't match") {
+		assert.Equal(t, diag.SyntaxError, errors[0].Code)
+		assert.Equal(t, "expected `=` or EndStmt after type in let, found integer `5`", errors[0].Message)
+		assert.Equal(t, 1, errors[0].Span.Line)
+		assert.Equal(t, "expected an expression, found `=`", errors[1].Message)
+		assert.Equal(t, 4, errors[1].Span.Line)
+		assert.Equal(t, "expected an expression, found `}`", errors[2].Message)
+		assert.Equal(t, 7, errors[2].Span.Line)
+	}
+
+	if assert.Equal(t, 3, len(source.Block.Body), "Valid statements should be kept") {
+		parseCmpNode(t, source.Block.Body[0].(*ast.Assign).Name, &ast.Ident{Value: "b"})
+		assert.Equal(t, 1, len(source.Block.Body[1].(*ast.Section).Block.Body))
+		parseCmpNode(t, source.Block.Body[2].(*ast.Assign).Name, &ast.Ident{Value: "d"})
+	}
+}
+
+func TestSyntaxErrorAtEOF(t *testing.T) {
+	for _, input := range []string{"server {", "let x = true\nif x {", "let a = [1,"} {
+		_, errLexer, errParser := tokenizeAndParse(input)
+
+		if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+			!assert.IsType(t, diag.Diagnostics{}, errParser, "Truncated input should be a syntax error") {
+			continue
+		}
+
+		assert.Equal(t, diag.SyntaxError, errParser.(diag.Diagnostics)[0].Code)
+	}
+}
+
+func TestStatementTerminators(t *testing.T) {
+	parseCmp(t, `let a = 1; let b = 2;;
+	writeln(a,
+		b)`, []ast.Node{
+		&ast.Assign{
+			Name: &ast.Ident{Value: "a"},
+			Value: &ast.Literal{Type: ast.Integer, Value: 1},
+		},
+		&ast.Assign{
+			Name: &ast.Ident{Value: "b"},
+			Value: &ast.Literal{Type: ast.Integer, Value: 2},
+		},
+		&ast.ExprStmt{
+			Expr: &ast.Call{
+				Callee: &ast.Ident{Value: "writeln"},
+				Args: []ast.Expr{&ast.Ident{Value: "a"}, &ast.Ident{Value: "b"}},
+			},
+		},
+	})
+
+	source, _, errParser := tokenizeAndParse("\n// nothing to see here\n")
+
+	if assert.Nil(t, errParser, "Comment-only files should parse") {
+		assert.Equal(t, 0, len(source.Block.Body))
+	}
+}
@@ -0,0 +1,381 @@
+package resolver
+
+import (
+	"fmt"
+	"dmeijboom/config/ast"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
+)
+
+var builtinTypes = []string{
+	"int", "bool", "string", "float", "object",
+}
+
+type Resolver struct {
+	WarnShadowing bool
+	source *ast.Source
+	root *Scope
+	scope *Scope
+	errors diag.Diagnostics
+	warnings diag.Diagnostics
+}
+
+func NewResolver(source *ast.Source) *Resolver {
+	root := NewScope(RootScope, "", nil)
+
+	return &Resolver{
+		WarnShadowing: true,
+		source: source,
+		root: root,
+		scope: root,
+	}
+}
+
+func (resolver *Resolver) Declare(name string) {
+	resolver.root.Values[name] = &Symbol{Name: name, Kind: BuiltinSymbol, Const: true, Scope: resolver.root}
+}
+
+func (resolver *Resolver) Root() *Scope {
+	return resolver.root
+}
+
+func (resolver *Resolver) Warnings() diag.Diagnostics {
+	return resolver.warnings
+}
+
+func (resolver *Resolver) Resolve() error {
+	resolver.block(resolver.source.Block)
+
+	if len(resolver.errors) > 0 {
+		return resolver.errors
+	}
+
+	return nil
+}
+
+func (resolver *Resolver) fail(code diag.Code, loc *tokens.Location, format string, args ...interface{}) *diag.Diagnostic {
+	diagnostic := diag.New(code, diag.At(loc), format, args...)
+	resolver.errors = append(resolver.errors, diagnostic)
+
+	return diagnostic
+}
+
+func (resolver *Resolver) openScope(kind ScopeKind, name string) *Scope {
+	resolver.scope = NewScope(kind, name, resolver.scope)
+	return resolver.scope
+}
+
+func (resolver *Resolver) closeScope() {
+	resolver.scope = resolver.scope.Parent
+}
+
+func (resolver *Resolver) declare(symbols map[string]*Symbol, symbol *Symbol, what string) {
+	if previous, exist := symbols[symbol.Name]; exist {
+		diagnostic := resolver.fail(diag.DuplicateDeclaration, symbol.Loc(), "Duplicate declaration of %s `%s`", what, symbol.Name)
+
+		if previous.Loc() != nil {
+			diagnostic.WithLabel(diag.At(previous.Loc()), "first declared here")
+		}
+
+		return
+	}
+
+	if resolver.WarnShadowing && resolver.scope.Parent != nil {
+		var outer *Symbol
+
+		if symbol.Kind == TypeSymbol {
+			outer = resolver.scope.Parent.LookupType(symbol.Name)
+		} else {
+			outer = resolver.scope.Parent.Lookup(symbol.Name)
+		}
+
+		if outer != nil {
+			diagnostic := diag.Warn(diag.ShadowedName, diag.At(symbol.Loc()), "Declaration of %s `%s` shadows an outer declaration", what, symbol.Name)
+
+			if outer.Loc() != nil {
+				diagnostic.WithLabel(diag.At(outer.Loc()), "shadowed declaration")
+			}
+
+			resolver.warnings = append(resolver.warnings, diagnostic)
+		}
+	}
+
+	symbol.Scope = resolver.scope
+	symbols[symbol.Name] = symbol
+}
+
+func (resolver *Resolver) declareValue(ident *ast.Ident, isConst bool) {
+	resolver.declare(resolver.scope.Values, &Symbol{
+		Name: ident.Value,
+		Kind: ValueSymbol,
+		Const: isConst,
+		Location: ident.Loc(),
+	}, "binding")
+}
+
+func (resolver *Resolver) undefined(ident *ast.Ident, what string, types bool) {
+	diagnostic := resolver.fail(diag.UndefinedName, ident.Loc(), "Undefined %s `%s`", what, ident.Value)
+
+	if suggestion := suggest(ident.Value, resolver.scope.visible(types)); suggestion != "" {
+		diagnostic.WithNote(fmt.Sprintf("did you mean `%s`?", suggestion))
+	}
+}
+
+func (resolver *Resolver) block(block *ast.Block) {
+	for _, node := range block.Body {
+		resolver.stmt(node)
+	}
+}
+
+func (resolver *Resolver) resolveType(node *ast.Type) {
+	if node.Base != nil {
+		resolver.resolveType(node.Base)
+	}
+
+	for _, field := range node.Fields {
+		resolver.resolveType(field.Type)
+	}
+
+	for _, typeName := range builtinTypes {
+		if typeName == node.Name.Value {
+			return
+		}
+	}
+
+	if resolver.scope.LookupType(node.Name.Value) == nil {
+		resolver.undefined(node.Name, "type", true)
+	}
+}
+
+func (resolver *Resolver) stmt(node ast.Node) {
+	switch stmt := node.(type) {
+	case *ast.Block:
+		resolver.openScope(BlockScope, "")
+		resolver.block(stmt)
+		resolver.closeScope()
+		break
+	case *ast.Typedef:
+		resolver.resolveType(stmt.Type)
+		resolver.declare(resolver.scope.Types, &Symbol{
+			Name: stmt.Name.Value,
+			Kind: TypeSymbol,
+			Location: stmt.Name.Loc(),
+		}, "type")
+		break
+	case *ast.Assign:
+		if stmt.Type != nil {
+			resolver.resolveType(stmt.Type)
+		}
+
+		if stmt.Value != nil {
+			resolver.expr(stmt.Value)
+		}
+
+		resolver.declareValue(stmt.Name, stmt.Const)
+		break
+	case *ast.Reassign:
+		resolver.expr(stmt.Value)
+		resolver.expr(stmt.Target)
+		resolver.sectionField(stmt.Target)
+		break
+	case *ast.Section:
+		resolver.section(stmt)
+		break
+	case *ast.Assert:
+		resolver.expr(stmt.Cond)
+		resolver.expr(stmt.Message)
+		break
+	case *ast.If:
+		resolver.expr(stmt.Cond)
+		resolver.openScope(BlockScope, "")
+		resolver.block(stmt.Then)
+		resolver.closeScope()
+
+		if stmt.Else != nil {
+			resolver.openScope(BlockScope, "")
+			resolver.block(stmt.Else)
+			resolver.closeScope()
+		}
+
+		break
+	case *ast.For:
+		resolver.expr(stmt.Collection)
+		resolver.openScope(BlockScope, "")
+
+		if stmt.Key != nil {
+			resolver.declareValue(stmt.Key, false)
+		}
+
+		resolver.declareValue(stmt.Value, false)
+		resolver.block(stmt.Block)
+		resolver.closeScope()
+		break
+	case *ast.ExprStmt:
+		resolver.expr(stmt.Expr)
+		break
+	case *ast.Import:
+		break
+	}
+}
+
+// sectionField declares a field that's set on a closed section, like
+// `server.tls = true`, so it's visible when the section is reopened
+func (resolver *Resolver) sectionField(target ast.Expr) {
+	member, isMember := target.(*ast.Member)
+
+	if !isMember {
+		return
+	}
+
+	object, isIdent := member.Object.(*ast.Ident)
+	field, isField := member.Field.(*ast.Ident)
+
+	if !isIdent || !isField {
+		return
+	}
+
+	symbol := resolver.scope.Lookup(object.Value)
+
+	if symbol == nil || symbol.Kind != SectionSymbol || symbol.Body == nil {
+		return
+	} else if _, exist := symbol.Body.Values[field.Value]; exist {
+		return
+	}
+
+	symbol.Body.Values[field.Value] = &Symbol{
+		Name: field.Value,
+		Kind: ValueSymbol,
+		Scope: symbol.Body,
+		Location: field.Loc(),
+	}
+}
+
+func (resolver *Resolver) section(section *ast.Section) {
+	name := section.Name.Value
+	symbol, exist := resolver.scope.Values[name]
+	merged := exist && symbol.Kind == SectionSymbol
+
+	if section.Type != nil {
+		resolver.resolveType(section.Type)
+	}
+
+	if !merged {
+		symbol = &Symbol{Name: name, Kind: SectionSymbol, Location: section.Loc()}
+		resolver.declare(resolver.scope.Values, symbol, "section")
+	}
+
+	scope := resolver.openScope(SectionScope, name)
+
+	// A repeated section is merged into the previous one, so its fields are already declared
+	if merged {
+		for fieldName, field := range symbol.Body.Values {
+			scope.Values[fieldName] = field
+		}
+	}
+
+	resolver.block(section.Block)
+	resolver.closeScope()
+	symbol.Body = scope
+}
+
+func (resolver *Resolver) expr(expr ast.Expr) {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		if resolver.scope.Lookup(node.Value) == nil {
+			resolver.undefined(node, "name", false)
+		}
+
+		break
+	case *ast.Member:
+		resolver.expr(node.Object)
+		break
+	case *ast.Call:
+		for _, arg := range node.Args {
+			resolver.expr(arg)
+		}
+
+		resolver.expr(node.Callee)
+		break
+	case *ast.Initialize:
+		if node.Type != nil {
+			resolver.resolveType(node.Type)
+		}
+
+		for _, spread := range node.Spreads {
+			resolver.expr(spread.Value)
+		}
+
+		for _, field := range node.Fields {
+			resolver.expr(field.Value)
+		}
+
+		break
+	case *ast.Binary:
+		resolver.expr(node.Left)
+		resolver.expr(node.Right)
+		break
+	case *ast.IsNull:
+		resolver.expr(node.Value)
+		break
+	case *ast.Not:
+		resolver.expr(node.Value)
+		break
+	case *ast.Conditional:
+		resolver.expr(node.Cond)
+		resolver.expr(node.Then)
+		resolver.expr(node.Else)
+		break
+	case *ast.Array:
+		for _, elem := range node.Elements {
+			resolver.expr(elem)
+		}
+
+		break
+	case *ast.Comprehension:
+		resolver.expr(node.Collection)
+		resolver.openScope(BlockScope, "")
+
+		if node.Key != nil {
+			resolver.declareValue(node.Key, false)
+		}
+
+		resolver.declareValue(node.Value, false)
+
+		if node.Filter != nil {
+			resolver.expr(node.Filter)
+		}
+
+		resolver.expr(node.Result)
+		resolver.closeScope()
+		break
+	case *ast.Match:
+		resolver.expr(node.Value)
+
+		for _, arm := range node.Arms {
+			resolver.openScope(BlockScope, "")
+			resolver.pattern(arm.Pattern)
+			resolver.expr(arm.Result)
+			resolver.closeScope()
+		}
+
+		break
+	}
+}
+
+func (resolver *Resolver) pattern(node ast.Pattern) {
+	typePattern, isTypePattern := node.(*ast.TypePattern)
+
+	if !isTypePattern {
+		return
+	}
+
+	resolver.resolveType(typePattern.Type)
+
+	for _, field := range typePattern.Fields {
+		if field.Pattern == nil {
+			resolver.declareValue(field.Name, false)
+		} else {
+			resolver.pattern(field.Pattern)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"dmeijboom/config/tokens"
+)
+
+type ScopeKind int
+
+const (
+	RootScope ScopeKind = iota
+	FunctionScope
+	BlockScope
+	SectionScope
+)
+
+type SymbolKind int
+
+const (
+	ValueSymbol SymbolKind = iota
+	TypeSymbol
+	SectionSymbol
+	BuiltinSymbol
+)
+
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	Const bool
+	Scope *Scope
+	Body *Scope
+	Location *tokens.Location
+}
+
+func (symbol *Symbol) Loc() *tokens.Location {
+	return symbol.Location
+}
+
+
+type Scope struct {
+	Kind ScopeKind
+	Name string
+	Parent *Scope
+	Children []*Scope
+	Values map[string]*Symbol
+	Types map[string]*Symbol
+}
+
+func NewScope(kind ScopeKind, name string, parent *Scope) *Scope {
+	scope := &Scope{
+		Kind: kind,
+		Name: name,
+		Parent: parent,
+		Values: map[string]*Symbol{},
+		Types: map[string]*Symbol{},
+	}
+
+	if parent != nil {
+		parent.Children = append(parent.Children, scope)
+	}
+
+	return scope
+}
+
+func (scope *Scope) Lookup(name string) *Symbol {
+	if symbol, exist := scope.Values[name]; exist {
+		return symbol
+	} else if scope.Parent != nil {
+		return scope.Parent.Lookup(name)
+	}
+
+	return nil
+}
+
+func (scope *Scope) LookupType(name string) *Symbol {
+	if symbol, exist := scope.Types[name]; exist {
+		return symbol
+	} else if scope.Parent != nil {
+		return scope.Parent.LookupType(name)
+	}
+
+	return nil
+}
+
+func (scope *Scope) visible(types bool) []string {
+	names := []string{}
+
+	for current := scope; current != nil; current = current.Parent {
+		symbols := current.Values
+
+		if types {
+			symbols = current.Types
+		}
+
+		for name := range symbols {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"sort"
+)
+
+func minInt(values ...int) int {
+	result := values[0]
+
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}
+
+func distance(a string, b string) int {
+	previous := make([]int, len(b) + 1)
+	current := make([]int, len(b) + 1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i - 1] == b[j - 1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func suggest(name string, candidates []string) string {
+	best := ""
+	bestDistance := len(name) / 3 + 1
+
+	sort.Strings(candidates)
+
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+
+		if d := distance(name, candidate); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+
+	return best
+}
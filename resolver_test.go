@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+func resolveSource(t *testing.T, input string, warnShadowing bool) (diag.Diagnostics, diag.Diagnostics) {
+	source, errLexer, errParser := tokenizeAndParse(input)
+
+	if !assert.Nil(t, errLexer, "Lexer shouldn't fail") ||
+		!assert.Nil(t, errParser, "Parser shouldn't fail") {
+		t.FailNow()
+	}
+
+	nameResolver := resolver.NewResolver(source)
+	nameResolver.WarnShadowing = warnShadowing
+	declareNames(nameResolver)
+
+	return diag.From(nameResolver.Resolve()), nameResolver.Warnings()
+}
+
+func TestResolveNames(t *testing.T) {
+	errors, warnings := resolveSource(t, filesystemType + `
+	network {
+		let hostname = "node"
+	}
+
+	network {
+		let mtu = 1500
+		let name = hostname
+	}
+
+	network.domain = "local"
+
+	network {
+		let fqdn = domain
+	}
+
+	let paths = [fs.path for fs in [base] if fs.opts == null]
+	writeln(paths)`, true)
+
+	assert.Nil(t, errors, "Valid names shouldn't fail")
+	assert.Nil(t, warnings, "Valid names shouldn't warn")
+}
+
+func TestResolveErrors(t *testing.T) {
+	errors, _ := resolveSource(t, filesystemType + `
+	let hostname = "node"
+	let fqdn = hostnme
+	let home: Filesytem = base
+	let base = "duplicate"
+	type Filesystem: object {}
+	writeln(x)`, true)
+
+	if !assert.Equal(t, 5, len(errors), "All resolve errors should be reported") {
+		t.Log(errors)
+		return
+	}
+
+	assert.Equal(t, diag.UndefinedName, errors[0].Code)
+	assert.Equal(t, "Undefined name `hostnme`", errors[0].Message)
+	assert.Equal(t, []string{"did you mean `hostname`?"}, errors[0].Notes)
+	assert.Equal(t, 15, errors[0].Span.Line)
+	assert.Equal(t, "Undefined type `Filesytem`", errors[1].Message)
+	assert.Equal(t, []string{"did you mean `Filesystem`?"}, errors[1].Notes)
+	assert.Equal(t, diag.DuplicateDeclaration, errors[2].Code)
+	assert.Equal(t, "Duplicate declaration of binding `base`", errors[2].Message)
+	assert.Equal(t, 8, errors[2].Labels[0].Span.Line)
+	assert.Equal(t, "Duplicate declaration of type `Filesystem`", errors[3].Message)
+	assert.Equal(t, "Undefined name `x`", errors[4].Message)
+	assert.Nil(t, errors[4].Notes, "Short names shouldn't get suggestions")
+
+	errors, _ = resolveSource(t, `network {
+		let hostname = "node"
+	}
+
+	network {
+		let hostname = "other"
+	}`, true)
+
+	if assert.Equal(t, 1, len(errors)) {
+		assert.Equal(t, "Duplicate declaration of binding `hostname`", errors[0].Message)
+	}
+}
+
+func TestResolveShadowing(t *testing.T) {
+	input := `let path = "/"
+	server {
+		let path = "/srv"
+	}
+	for path in ["/home"] {
+		writeln(path)
+	}`
+
+	errors, warnings := resolveSource(t, input, true)
+
+	assert.Nil(t, errors, "Shadowing isn't an error")
+
+	if assert.Equal(t, 2, len(warnings), "Shadowed declarations should warn") {
+		assert.Equal(t, diag.Warning, warnings[0].Severity)
+		assert.Equal(t, diag.ShadowedName, warnings[0].Code)
+		assert.Equal(t, "Declaration of binding `path` shadows an outer declaration", warnings[0].Message)
+		assert.Equal(t, 3, warnings[0].Span.Line)
+		assert.Equal(t, 1, warnings[0].Labels[0].Span.Line)
+	}
+
+	_, warnings = resolveSource(t, input, false)
+	assert.Nil(t, warnings, "Shadowing warnings should be configurable")
+}
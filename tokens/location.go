@@ -3,4 +3,5 @@ package tokens
 type Location struct {
 	Line int
 	Column int
+	File string
 }
@@ -16,11 +16,23 @@ const (
 	LSqrBracket
 	RSqrBracket
 	Colon
+	Comma
 	Query
+	OptionalChain
+	Coalesce
 	Equals
+	PlusEquals
+	Eq
+	NotEq
+	Not
+	FatArrow
 	Interpunct
+	Spread
+	At
+	DocComment
 	String
 	Boolean
+	Null
 	Integer
 	Float
 	EndStmt
@@ -58,14 +70,32 @@ func (kind TokenKind) String() string {
 		return "RSqrBracket"
 	case Colon:
 		return "Colon"
+	case Comma:
+		return "Comma"
 	case Query:
 		return "Query"
+	case OptionalChain:
+		return "OptionalChain"
+	case Coalesce:
+		return "Coalesce"
 	case Equals:
 		return "Equals"
+	case PlusEquals:
+		return "PlusEquals"
+	case Eq:
+		return "Eq"
+	case NotEq:
+		return "NotEq"
+	case Not:
+		return "Not"
+	case FatArrow:
+		return "FatArrow"
 	case String:
 		return "String"
 	case Boolean:
 		return "Boolean"
+	case Null:
+		return "Null"
 	case Integer:
 		return "Integer"
 	case Float:
@@ -74,6 +104,12 @@ func (kind TokenKind) String() string {
 		return "Keyword"
 	case Interpunct:
 		return "Interpunct"
+	case Spread:
+		return "Spread"
+	case At:
+		return "At"
+	case DocComment:
+		return "DocComment"
 	case EndStmt:
 		return "EndStmt"
 	default:
@@ -0,0 +1,33 @@
+package vm
+
+import "dmeijboom/config/compiler"
+
+type Annotation struct {
+	Name string
+	Args []interface{}
+}
+
+type AnnotationHandler func(annotation *Annotation, name string, value *Value) (*Value, error)
+
+func convertAnnotations(annotations []compiler.Annotation) []Annotation {
+	converted := []Annotation{}
+
+	for _, annotation := range annotations {
+		converted = append(converted, Annotation{
+			Name: annotation.Name,
+			Args: annotation.Args,
+		})
+	}
+
+	return converted
+}
+
+func findAnnotation(annotations []Annotation, name string) *Annotation {
+	for i := range annotations {
+		if annotations[i].Name == name {
+			return &annotations[i]
+		}
+	}
+
+	return nil
+}
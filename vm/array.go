@@ -1,6 +1,7 @@
 package vm
 
 type Array struct {
+	frozen bool
 	values []*Value
 }
 
@@ -13,3 +14,15 @@ func NewArray() *Array {
 func (array *Array) Add(value *Value) {
 	array.values = append(array.values, value)
 }
+
+func (array *Array) Values() []*Value {
+	return array.values
+}
+
+func (array *Array) Freeze() {
+	array.frozen = true
+}
+
+func (array *Array) Frozen() bool {
+	return array.frozen
+}
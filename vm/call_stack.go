@@ -1,5 +1,11 @@
 package vm
 
+import (
+	"strings"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
+)
+
 type CallStack struct {
 	frames []*Frame
 }
@@ -26,3 +32,33 @@ func (callStack *CallStack) Frame() *Frame {
 
 	return callStack.frames[len(callStack.frames)-1]
 }
+
+// Trace lists the active frames from the innermost one out. The innermost
+// frame is at loc, every other frame is where it entered the frame above it.
+func (callStack *CallStack) Trace(loc *tokens.Location) []diag.TraceFrame {
+	trace := make([]diag.TraceFrame, len(callStack.frames))
+	sections := []string{}
+
+	for i, frame := range callStack.frames {
+		if frame.Kind == SectionFrame {
+			sections = append(sections, frame.SectionName)
+		}
+
+		trace[i] = diag.TraceFrame{
+			Kind: frame.Kind.String(),
+			Function: frame.FunctionName,
+			Section: strings.Join(sections, "."),
+		}
+	}
+
+	for i := len(callStack.frames) - 1; i >= 0; i-- {
+		trace[i].Span = diag.At(loc)
+		loc = callStack.frames[i].Location
+	}
+
+	for i, j := 0, len(trace) - 1; i < j; i, j = i + 1, j - 1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+
+	return trace
+}
@@ -26,3 +26,7 @@ func (dataStack *DataStack) Elem() interface{} {
 	return dataStack.elements[len(dataStack.elements)-1]
 }
 
+func (dataStack *DataStack) Peek(depth int) interface{} {
+	return dataStack.elements[len(dataStack.elements)-1-depth]
+}
+
@@ -8,32 +8,192 @@ const (
 	RootFrame FrameKind = iota
 	FunctionFrame
 	BlockFrame
+	SectionFrame
 )
 
+func (kind FrameKind) String() string {
+	switch kind {
+	case RootFrame:
+		return "root"
+	case FunctionFrame:
+		return "function"
+	case BlockFrame:
+		return "block"
+	case SectionFrame:
+		return "section"
+	}
+
+	return "unknown"
+}
+
+
 type Frame struct {
 	Kind FrameKind
 	Parent *Frame
 	FunctionName string
-	Data map[string]*Value
+	SectionName string
+	SectionSlot int
+	SectionType *Type
+	Sections map[string]*Frame
+	Merged []bool
+	Names []string
+	Values []*Value
+	ValueTypes []*Type
+	ValueAnnotations [][]Annotation
 	Types map[string]*Type
 	Location *tokens.Location
+	index map[string]int
 }
 
-func NewFrame(kind FrameKind, loc *tokens.Location) *Frame {
+func NewFrame(kind FrameKind, loc *tokens.Location, names []string) *Frame {
 	return &Frame{
 		Kind: kind,
 		Location: loc,
-		Data: map[string]*Value{},
-		Types: map[string]*Type{},
+		Names: names,
+		Values: make([]*Value, len(names)),
+	}
+}
+
+// Slot looks up a binding by name, which is only needed for names the
+// compiler couldn't resolve and for the embedding API
+func (frame *Frame) Slot(name string) int {
+	if len(frame.Names) <= 16 {
+		for slot, slotName := range frame.Names {
+			if slotName == name {
+				return slot
+			}
+		}
+
+		return -1
+	}
+
+	if frame.index == nil {
+		frame.index = make(map[string]int, len(frame.Names))
+
+		for slot, slotName := range frame.Names {
+			frame.index[slotName] = slot
+		}
+	}
+
+	if slot, exist := frame.index[name]; exist {
+		return slot
+	}
+
+	return -1
+}
+
+func (frame *Frame) Declare(name string) int {
+	if slot := frame.Slot(name); slot != -1 {
+		return slot
+	}
+
+	slot := len(frame.Names)
+
+	// The names are shared with the program so they're copied before growing
+	frame.Names = append(frame.Names[:slot:slot], name)
+	frame.Values = append(frame.Values, nil)
+
+	if frame.index != nil {
+		frame.index[name] = slot
+	}
+
+	if frame.ValueTypes != nil {
+		frame.ValueTypes = append(frame.ValueTypes, nil)
+	}
+
+	if frame.ValueAnnotations != nil {
+		frame.ValueAnnotations = append(frame.ValueAnnotations, nil)
 	}
+
+	if frame.Merged != nil {
+		frame.Merged = append(frame.Merged, false)
+	}
+
+	return slot
+}
+
+func (frame *Frame) Ancestor(depth int) *Frame {
+	for ; depth > 0; depth-- {
+		frame = frame.Parent
+	}
+
+	return frame
 }
 
 func (frame *Frame) Get(name string) *Value {
-	if value, exist := frame.Data[name]; exist {
-		return value
-	} else if frame.Parent != nil {
-		return frame.Parent.Get(name)
+	if owner, slot := frame.Owner(name); owner != nil {
+		return owner.Values[slot]
 	}
 
 	return nil
 }
+
+func (frame *Frame) Owner(name string) (*Frame, int) {
+	for ; frame != nil; frame = frame.Parent {
+		if slot := frame.Slot(name); slot != -1 && frame.Values[slot] != nil {
+			return frame, slot
+		}
+	}
+
+	return nil, -1
+}
+
+func (frame *Frame) setType(name string, typeDef *Type) {
+	if frame.Types == nil {
+		frame.Types = map[string]*Type{}
+	}
+
+	frame.Types[name] = typeDef
+}
+
+func (frame *Frame) setSection(name string, section *Frame) {
+	if frame.Sections == nil {
+		frame.Sections = map[string]*Frame{}
+	}
+
+	frame.Sections[name] = section
+}
+
+func (frame *Frame) ValueType(slot int) *Type {
+	if frame.ValueTypes == nil {
+		return nil
+	}
+
+	return frame.ValueTypes[slot]
+}
+
+func (frame *Frame) SetValueType(slot int, valueType *Type) {
+	if frame.ValueTypes == nil {
+		frame.ValueTypes = make([]*Type, len(frame.Values))
+	}
+
+	frame.ValueTypes[slot] = valueType
+}
+
+func (frame *Frame) Annotations(slot int) []Annotation {
+	if frame.ValueAnnotations == nil {
+		return nil
+	}
+
+	return frame.ValueAnnotations[slot]
+}
+
+func (frame *Frame) SetAnnotations(slot int, annotations []Annotation) {
+	if frame.ValueAnnotations == nil {
+		frame.ValueAnnotations = make([][]Annotation, len(frame.Values))
+	}
+
+	frame.ValueAnnotations[slot] = annotations
+}
+
+func (frame *Frame) IsMerged(slot int) bool {
+	return frame.Merged != nil && frame.Merged[slot]
+}
+
+func (frame *Frame) SetMerged(slot int) {
+	if frame.Merged == nil {
+		frame.Merged = make([]bool, len(frame.Values))
+	}
+
+	frame.Merged[slot] = true
+}
@@ -7,6 +7,10 @@ package vm
 
 type GoFunc func (values []*Value)
 
+// GoErrorFunc is a builtin that can fail, the error is reported as a runtime
+// error at the call
+type GoErrorFunc func (values []*Value) error
+
 type FunctionLookup struct {
 	Name string
 	Value *Value
@@ -15,5 +19,6 @@ type FunctionLookup struct {
 type Function struct {
 	Name string
 	Func GoFunc
+	ErrorFunc GoErrorFunc
 	// Args []FunctionArgument
 }
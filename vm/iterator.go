@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"sort"
+)
+
+type Iterator struct {
+	index int
+	keys []*Value
+	values []*Value
+}
+
+func NewIterator(collection *Value) *Iterator {
+	iterator := &Iterator{
+		keys: []*Value{},
+		values: []*Value{},
+	}
+
+	switch collection.Type.Id {
+	case ArrayType:
+		for i, value := range collection.Value.(*Array).Values() {
+			iterator.keys = append(iterator.keys, &Value{
+				Type: &Type{Id: IntegerType, Name: "int"},
+				Value: i,
+			})
+			iterator.values = append(iterator.values, value)
+		}
+		break
+	case ObjectType:
+		fields := collection.Value.(*Object).Fields
+		names := []string{}
+
+		for name := range fields {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			iterator.keys = append(iterator.keys, &Value{
+				Type: &Type{Id: StringType, Name: "string"},
+				Value: name,
+			})
+			iterator.values = append(iterator.values, fields[name])
+		}
+		break
+	}
+
+	return iterator
+}
+
+func (iterator *Iterator) Next() (*Value, *Value, bool) {
+	if iterator.index >= len(iterator.values) {
+		return nil, nil, false
+	}
+
+	iterator.index++
+	return iterator.keys[iterator.index-1], iterator.values[iterator.index-1], true
+}
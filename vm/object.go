@@ -3,9 +3,16 @@ package vm
 type ObjectField struct {
 	Name string
 	Type *Type
+	Doc string
+	Annotations []Annotation
+}
+
+func (field *ObjectField) Annotation(name string) *Annotation {
+	return findAnnotation(field.Annotations, name)
 }
 
 type ObjectDef struct {
+	Base *Type
 	Fields []ObjectField
 }
 
@@ -21,6 +28,7 @@ func (objectDef *ObjectDef) FieldByName(name string) *ObjectField {
 
 
 type Object struct {
+	frozen bool
 	Fields map[string]*Value
 }
 
@@ -29,3 +37,11 @@ func NewObject() *Object {
 		Fields: map[string]*Value{},
 	}
 }
+
+func (object *Object) Freeze() {
+	object.frozen = true
+}
+
+func (object *Object) Frozen() bool {
+	return object.frozen
+}
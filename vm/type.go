@@ -10,6 +10,7 @@ const (
 	BooleanType
 	FunctionType
 	ObjectType
+	NullType
 )
 
 type Type struct {
@@ -18,12 +19,20 @@ type Type struct {
 	Optional bool
 	ObjectDef *ObjectDef
 	GenericParams []Type
+	Doc string
+	Annotations []Annotation
+}
+
+func (type_ *Type) Annotation(name string) *Annotation {
+	return findAnnotation(type_.Annotations, name)
 }
 
 func (type_ *Type) FullName() string {
 	typeName := type_.Name
 
-	if type_.Id == ArrayType {
+	if type_.Id == ArrayType && len(type_.GenericParams) == 0 {
+		typeName = "[]"
+	} else if type_.Id == ArrayType {
 		typeName = "[]" + (&type_.GenericParams[0]).FullName()
 	} else if len(type_.GenericParams) > 0 {
 		typeName += "["
@@ -63,3 +72,17 @@ func (type_ *Type) Equals(otherType *Type) bool {
 
 	return true
 }
+
+func (type_ *Type) AssignableTo(otherType *Type) bool {
+	if type_.Equals(otherType) {
+		return true
+	} else if type_.Id == NullType {
+		return otherType.Optional
+	}
+
+	if type_.Id == ObjectType && type_.ObjectDef != nil && type_.ObjectDef.Base != nil {
+		return type_.ObjectDef.Base.AssignableTo(otherType)
+	}
+
+	return false
+}
@@ -5,3 +5,97 @@ type Value struct {
 	Mutable bool
 	Value interface{}
 }
+
+func NewNull() *Value {
+	return &Value{
+		Type: &Type{Id: NullType, Name: "null", Optional: true},
+	}
+}
+
+func (value *Value) IsNull() bool {
+	return value.Type.Id == NullType || value.Value == nil
+}
+
+func (value *Value) Equals(otherValue *Value) bool {
+	if value.IsNull() || otherValue.IsNull() {
+		return value.IsNull() && otherValue.IsNull()
+	}
+
+	return value.Type.Equals(otherValue.Type) && value.Value == otherValue.Value
+}
+
+func (value *Value) Frozen() bool {
+	switch container := value.Value.(type) {
+	case *Object:
+		return container.Frozen()
+	case *Array:
+		return container.Frozen()
+	}
+
+	return false
+}
+
+// Copy returns a deep copy of the value, so freezing the copy doesn't freeze
+// the objects and arrays other bindings still refer to
+func (value *Value) Copy() *Value {
+	copied := *value
+
+	switch container := value.Value.(type) {
+	case *Object:
+		object := NewObject()
+
+		for name, field := range container.Fields {
+			if field != nil {
+				field = field.Copy()
+			}
+
+			object.Fields[name] = field
+		}
+
+		copied.Value = object
+		break
+	case *Array:
+		// Appending to an untyped array sets its element type
+		arrayType := *value.Type
+		array := NewArray()
+
+		for _, elem := range container.Values() {
+			array.Add(elem.Copy())
+		}
+
+		copied.Type = &arrayType
+		copied.Value = array
+		break
+	}
+
+	return &copied
+}
+
+func (value *Value) Freeze() {
+	value.Mutable = false
+
+	switch container := value.Value.(type) {
+	case *Object:
+		if container.Frozen() {
+			return
+		}
+
+		container.Freeze()
+
+		for _, field := range container.Fields {
+			field.Freeze()
+		}
+		break
+	case *Array:
+		if container.Frozen() {
+			return
+		}
+
+		container.Freeze()
+
+		for _, elem := range container.Values() {
+			elem.Freeze()
+		}
+		break
+	}
+}
@@ -2,23 +2,80 @@ package vm
 
 import (
 	"fmt"
+	"sort"
+	"dmeijboom/config/diag"
+	"dmeijboom/config/tokens"
 	"dmeijboom/config/compiler"
 )
 
+type handler func(vm *VirtualMachine, operands []int32) error
+
+var handlers [compiler.OpcodeCount]handler
+
+func init() {
+	handlers = [compiler.OpcodeCount]handler{
+		compiler.OpLoadConst: (*VirtualMachine).processLoadConst,
+		compiler.OpLoadVal: (*VirtualMachine).processLoadVal,
+		compiler.OpLoadSlot: (*VirtualMachine).processLoadSlot,
+		compiler.OpLoadMember: (*VirtualMachine).processLoadMember,
+		compiler.OpLoadType: (*VirtualMachine).processLoadType,
+		compiler.OpMakeField: (*VirtualMachine).processMakeField,
+		compiler.OpMakeObject: (*VirtualMachine).processMakeObject,
+		compiler.OpMakeType: (*VirtualMachine).processMakeType,
+		compiler.OpStoreVal: (*VirtualMachine).processStoreVal,
+		compiler.OpReassign: (*VirtualMachine).processReassign,
+		compiler.OpReassignSlot: (*VirtualMachine).processReassignSlot,
+		compiler.OpSetMember: (*VirtualMachine).processSetMember,
+		compiler.OpNewObject: (*VirtualMachine).processNewObject,
+		compiler.OpSetField: (*VirtualMachine).processSetField,
+		compiler.OpSpreadObject: (*VirtualMachine).processSpreadObject,
+		compiler.OpInitialize: (*VirtualMachine).processInitialize,
+		compiler.OpMakeCall: (*VirtualMachine).processMakeCall,
+		compiler.OpOpenSection: (*VirtualMachine).processOpenSection,
+		compiler.OpCloseSection: (*VirtualMachine).processCloseSection,
+		compiler.OpAssert: (*VirtualMachine).processAssert,
+		compiler.OpCoalesce: (*VirtualMachine).processCoalesce,
+		compiler.OpIsNull: (*VirtualMachine).processIsNull,
+		compiler.OpCompare: (*VirtualMachine).processCompare,
+		compiler.OpNot: (*VirtualMachine).processNot,
+		compiler.OpJump: (*VirtualMachine).processJump,
+		compiler.OpJumpIfFalse: (*VirtualMachine).processJumpIfFalse,
+		compiler.OpPushFrame: (*VirtualMachine).processPushFrame,
+		compiler.OpPopFrame: (*VirtualMachine).processPopFrame,
+		compiler.OpNewArray: (*VirtualMachine).processNewArray,
+		compiler.OpAppendArray: (*VirtualMachine).processAppendArray,
+		compiler.OpGetIter: (*VirtualMachine).processGetIter,
+		compiler.OpForIter: (*VirtualMachine).processForIter,
+		compiler.OpPop: (*VirtualMachine).processPop,
+		compiler.OpMatchPattern: (*VirtualMachine).processMatchPattern,
+		compiler.OpMatchFail: (*VirtualMachine).processMatchFail,
+	}
+}
+
 type VirtualMachine struct {
 	index int
+	offset int
 	root *Frame
 	callStack *CallStack
 	dataStack *DataStack
-	instructions []compiler.Instruction
+	program *compiler.Program
+	assertionErrors diag.Diagnostics
+	annotationHandlers map[string]AnnotationHandler
 }
 
-func NewVm(instructions []compiler.Instruction) *VirtualMachine {
+func NewVm(program *compiler.Program) *VirtualMachine {
+	var names []string
+
+	if len(program.Scopes) > 0 {
+		names = program.Scopes[0].Names
+	}
+
 	vm := &VirtualMachine{
-		root: NewFrame(RootFrame, nil),
+		root: NewFrame(RootFrame, nil, names),
 		callStack: NewCallStack(),
 		dataStack: NewDataStack(),
-		instructions: instructions,
+		program: program,
+		annotationHandlers: map[string]AnnotationHandler{},
 	}
 
 	vm.callStack.Push(vm.root)
@@ -27,21 +84,106 @@ func NewVm(instructions []compiler.Instruction) *VirtualMachine {
 }
 
 func (vm *VirtualMachine) Set(name string, value *Value) {
-	vm.root.Data[name] = value
+	vm.root.Values[vm.root.Declare(name)] = value
+}
+
+func (vm *VirtualMachine) Get(name string) *Value {
+	return vm.root.Get(name)
+}
+
+func (vm *VirtualMachine) Annotations(name string) []Annotation {
+	if frame, slot := vm.root.Owner(name); frame != nil {
+		return frame.Annotations(slot)
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) RegisterAnnotation(name string, handler AnnotationHandler) {
+	vm.annotationHandlers[name] = handler
+}
+
+func (vm *VirtualMachine) annotate(annotations []Annotation, name string, value *Value) (*Value, error) {
+	for i := range annotations {
+		handler, exist := vm.annotationHandlers[annotations[i].Name]
+
+		if !exist {
+			continue
+		}
+
+		result, err := handler(&annotations[i], name, value)
+
+		if err != nil {
+			return nil, err
+		} else if result != nil {
+			value = result
+		}
+	}
+
+	return value, nil
 }
 
 func (vm *VirtualMachine) hasInstructions() bool {
-	return vm.index <= len(vm.instructions)-1
+	return vm.index < len(vm.program.Code)
+}
+
+func (vm *VirtualMachine) peek() compiler.Opcode {
+	if !vm.hasInstructions() {
+		return compiler.OpcodeCount
+	}
+
+	return compiler.Opcode(vm.program.Code[vm.index])
+}
+
+func (vm *VirtualMachine) loc() *tokens.Location {
+	return vm.program.Loc(vm.offset)
 }
 
-func (vm *VirtualMachine) next() compiler.Instruction {
-	instr := vm.instructions[vm.index]
-	vm.index++
-	return instr
+func (vm *VirtualMachine) name(index int32) string {
+	return vm.program.Names[index]
+}
+
+func (vm *VirtualMachine) doc(index int32) string {
+	if index == -1 {
+		return ""
+	}
+
+	return vm.program.Constants[index].(string)
 }
 
-func (vm *VirtualMachine) peek() compiler.Instruction {
-	return vm.instructions[vm.index]
+func (vm *VirtualMachine) annotations(index int32) []Annotation {
+	if index == -1 {
+		return convertAnnotations(nil)
+	}
+
+	return convertAnnotations(vm.program.Constants[index].([]compiler.Annotation))
+}
+
+func (vm *VirtualMachine) popRaw() interface{} {
+	elem := vm.dataStack.Pop()
+
+	if value, isValue := elem.(*Value); isValue {
+		return value.Value
+	}
+
+	return elem
+}
+
+func (vm *VirtualMachine) popBool() (bool, error) {
+	value := vm.dataStack.Pop().(*Value)
+
+	if value.Type.Id != BooleanType || value.IsNull() {
+		return false, fmt.Errorf("Cannot use %s as a condition", value.Type.FullName())
+	}
+
+	return value.Value.(bool), nil
+}
+
+func (vm *VirtualMachine) pushBool(value bool) {
+	vm.dataStack.Push(&Value{
+		Type: vm.convertType(compiler.BooleanType),
+		Value: value,
+	})
 }
 
 func (vm *VirtualMachine) convertType(compilerType compiler.TypeId) *Type {
@@ -54,11 +196,28 @@ func (vm *VirtualMachine) convertType(compilerType compiler.TypeId) *Type {
 		return &Type{Id: BooleanType, Name: "bool"}
 	case compiler.FloatType:
 		return &Type{Id: FloatType, Name: "float"}
+	case compiler.NullType:
+		return NewNull().Type
 	}
 
 	panic("Unknown type in convertType")
 }
 
+func (vm *VirtualMachine) constType(value interface{}) *Type {
+	switch value.(type) {
+	case int:
+		return vm.convertType(compiler.IntegerType)
+	case float64:
+		return vm.convertType(compiler.FloatType)
+	case bool:
+		return vm.convertType(compiler.BooleanType)
+	case nil:
+		return vm.convertType(compiler.NullType)
+	}
+
+	return vm.convertType(compiler.StringType)
+}
+
 func (vm *VirtualMachine) lookupType(name string) *Type {
 	frame := vm.callStack.Frame()
 
@@ -86,93 +245,318 @@ func (vm *VirtualMachine) lookupFunction(lookup *FunctionLookup) (*Function, err
 	return value.Value.(*Function), nil
 }
 
-func (vm *VirtualMachine) processOpenSection(instruction *compiler.OpenSection) error {
-	vm.dataStack.Pop()
-	vm.callStack.Push(NewFrame(BlockFrame, instruction.Location))
+func (vm *VirtualMachine) processOpenSection(operands []int32) error {
+	frame := NewFrame(SectionFrame, vm.loc(), vm.program.Scopes[operands[2]].Names)
+	frame.SectionName = vm.name(operands[0])
+
+	if operands[1] == 1 {
+		frame.SectionType = vm.dataStack.Pop().(*Type)
+
+		if frame.SectionType.Id != ObjectType || frame.SectionType.Optional {
+			return fmt.Errorf("Cannot declare section `%s` as non-object type %s", frame.SectionName, frame.SectionType.FullName())
+		}
+	}
+
+	if err := vm.reopenSection(frame); err != nil {
+		return err
+	}
+
+	vm.callStack.Push(frame)
 	return nil
 }
 
-func (vm *VirtualMachine) processCloseSection(instruction *compiler.CloseSection) error {
-	vm.callStack.Pop()
+func (vm *VirtualMachine) reopenSection(frame *Frame) error {
+	parent := vm.callStack.Frame()
+	slot := parent.Slot(frame.SectionName)
+
+	if slot == -1 || parent.Values[slot] == nil {
+		return nil
+	}
+
+	existing := parent.Values[slot]
+	previous, isSection := parent.Sections[frame.SectionName]
+
+	if !isSection || existing.IsNull() || existing.Type.Id != ObjectType {
+		return fmt.Errorf("Name `%s` is already defined", frame.SectionName)
+	} else if frame.SectionType == nil {
+		frame.SectionType = previous.SectionType
+	} else if previous.SectionType != nil && !frame.SectionType.Equals(previous.SectionType) {
+		return fmt.Errorf("Cannot reopen section `%s` of type %s as %s", frame.SectionName, previous.SectionType.FullName(), frame.SectionType.FullName())
+	}
+
+	fields := existing.Value.(*Object).Fields
+	names := []string{}
+
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := fields[name]
+		copied := *field
+
+		if array, isArray := field.Value.(*Array); isArray {
+			arrayType := *field.Type
+			elems := NewArray()
+
+			for _, elem := range array.Values() {
+				elems.Add(elem)
+			}
+
+			copied.Type = &arrayType
+			copied.Value = elems
+		}
+
+		// Fields set after the section was closed may not have a slot in the compiled layout
+		fieldSlot := frame.Declare(name)
+		frame.Values[fieldSlot] = &copied
+		frame.SetMerged(fieldSlot)
+
+		if frame.SectionType != nil {
+			if declared := frame.SectionType.ObjectDef.FieldByName(name); declared != nil {
+				frame.SetValueType(fieldSlot, declared.Type)
+			}
+		}
+	}
+
+	for name, section := range previous.Sections {
+		frame.setSection(name, section)
+	}
+
 	return nil
 }
 
-func (vm *VirtualMachine) processMakeField(instruction *compiler.MakeField) error {
-	name := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) sectionValue(frame *Frame) (*Value, error) {
+	object := NewObject()
+	slots := []int{}
+
+	for slot, value := range frame.Values {
+		if value != nil {
+			slots = append(slots, slot)
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		return frame.Names[slots[i]] < frame.Names[slots[j]]
+	})
+
+	if frame.SectionType == nil {
+		sectionType := &Type{
+			Id: ObjectType,
+			Name: frame.SectionName,
+			ObjectDef: &ObjectDef{},
+		}
+
+		for _, slot := range slots {
+			name := frame.Names[slot]
+			object.Fields[name] = frame.Values[slot]
+			sectionType.ObjectDef.Fields = append(sectionType.ObjectDef.Fields, ObjectField{
+				Name: name,
+				Type: frame.Values[slot].Type,
+			})
+		}
+
+		return &Value{Type: sectionType, Value: object}, nil
+	}
+
+	objectDef := frame.SectionType.ObjectDef
+
+	for _, slot := range slots {
+		name := frame.Names[slot]
+		value := frame.Values[slot]
+		field := objectDef.FieldByName(name)
+
+		if field == nil {
+			return nil, fmt.Errorf("%s does not contain the `%s` field", frame.SectionType.FullName(), name)
+		} else if !value.Type.AssignableTo(field.Type) {
+			return nil, fmt.Errorf("Cannot use `%s` type %s as type %s", name, value.Type.FullName(), field.Type.FullName())
+		} else if !value.IsNull() {
+			object.Fields[name] = value
+		}
+	}
+
+	return &Value{Type: frame.SectionType, Value: object}, nil
+}
+
+func (vm *VirtualMachine) processCloseSection(operands []int32) error {
+	frame := vm.callStack.Pop()
+	value, err := vm.sectionValue(frame)
+
+	if err != nil {
+		return err
+	}
+
+	parent := vm.callStack.Frame()
+	value.Mutable = true
+	frame.SectionSlot = int(operands[0])
+	parent.Values[frame.SectionSlot] = value
+	parent.setSection(frame.SectionName, frame)
+	return nil
+}
+
+func (vm *VirtualMachine) checkSections(frame *Frame) error {
+	names := []string{}
+
+	for name := range frame.Sections {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		section := frame.Sections[name]
+		value := frame.Values[section.SectionSlot]
+
+		if err := vm.checkSections(section); err != nil {
+			return err
+		} else if section.SectionType == nil || value.IsNull() || value.Type.Id != ObjectType {
+			continue
+		}
+
+		object := value.Value.(*Object)
+
+		for _, field := range section.SectionType.ObjectDef.Fields {
+			if _, exist := object.Fields[field.Name]; !exist && !field.Type.Optional {
+				return diag.New(diag.RuntimeError, diag.At(section.Location), "Section `%s` is missing the non-optional `%s` field", name, field.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) processMakeField(operands []int32) error {
 	fieldType := vm.dataStack.Pop().(*Type)
 
     vm.dataStack.Push(&ObjectField{
-        Name: name,
+        Name: vm.name(operands[0]),
         Type: fieldType,
+        Doc: vm.doc(operands[1]),
+        Annotations: vm.annotations(operands[2]),
 	})
 	return nil
 }
 
-func (vm *VirtualMachine) processLoadType(instruction *compiler.LoadType) error {
+func (vm *VirtualMachine) resolveType(typeId compiler.TypeId, typeName string, array bool, optional bool) (*Type, error) {
 	var rtype *Type
 
-	typeName := vm.dataStack.Pop().(string)
-
-    if instruction.Type == compiler.UserType {
+    if typeId == compiler.UserType {
         rtype = vm.lookupType(typeName)
 
         if rtype == nil {
-            panic("Type not found: " + typeName)
+            return nil, fmt.Errorf("Type `%s` not found", typeName)
         }
+
+		copied := *rtype
+		rtype = &copied
     } else {
-        rtype = vm.convertType(instruction.Type)
+        rtype = vm.convertType(typeId)
     }
 
-    if !instruction.Array {
-		rtype.Optional = instruction.Optional
-        vm.dataStack.Push(rtype)
-        return nil
+    if !array {
+		rtype.Optional = optional
+        return rtype, nil
 	}
 	
-    vm.dataStack.Push(&Type{
+    return &Type{
 		Id: ArrayType,
 		Name: "array",
-		Optional: instruction.Optional,
+		Optional: optional,
         GenericParams: []Type{*rtype},
-    })
+    }, nil
+}
+
+func (vm *VirtualMachine) processLoadType(operands []int32) error {
+	typeName := ""
+	flags := compiler.TypeFlags(operands[1])
+
+	if operands[2] != -1 {
+		typeName = vm.name(operands[2])
+	}
+
+	rtype, err := vm.resolveType(compiler.TypeId(operands[0]), typeName, flags & compiler.TypeArray != 0, flags & compiler.TypeOptional != 0)
+
+	if err != nil {
+		return err
+	}
+
+	vm.dataStack.Push(rtype)
 	return nil
 }
 
-func (vm *VirtualMachine) processMakeType(instruction *compiler.MakeType) error {
-	name := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) processMakeType(operands []int32) error {
+	name := vm.name(operands[0])
 	def := vm.dataStack.Pop()
 
     if objectDef, ok := def.(*ObjectDef); ok {
-        vm.callStack.Frame().Types[name] = &Type{
+        vm.callStack.Frame().setType(name, &Type{
             Id: ObjectType,
             Name: name,
             ObjectDef: objectDef,
-        }
-    } else {
-        panic("Not supported")
+            Doc: vm.doc(operands[1]),
+            Annotations: vm.annotations(operands[2]),
+        })
+    } else if alias, ok := def.(*Type); ok {
+		copied := *alias
+		copied.Doc = vm.doc(operands[1])
+		copied.Annotations = vm.annotations(operands[2])
+		vm.callStack.Frame().setType(name, &copied)
+	} else {
+		return diag.New(diag.InternalError, diag.Span{}, "Cannot define type `%s` from %T", name, def)
 	}
 	
 	return nil
 }
 
-func (vm *VirtualMachine) processStoreVal(instruction *compiler.StoreVal) error {
-	name := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) processStoreVal(operands []int32) error {
+	slot := int(operands[0])
+	name := vm.callStack.Frame().Names[slot]
+	flags := compiler.StoreFlags(operands[1])
+	isConst := flags & compiler.StoreConst != 0
+	annotations := vm.annotations(operands[2])
 	var rawValue interface{}
+	var valueType *Type
 
-    if instruction.HasValue {
+    if flags & compiler.StoreValue != 0 {
 		rawValue = vm.dataStack.Pop().(interface{})
 	}
 
-	valueType := vm.dataStack.Pop().(*Type)
+	if flags & compiler.StoreTyped != 0 {
+		valueType = vm.dataStack.Pop().(*Type)
+	}
+
 	value, isValue := rawValue.(*Value)
 
+	if valueType == nil {
+		if !isValue {
+			return fmt.Errorf("Cannot infer the type of `%s`", name)
+		}
+
+		return vm.bind(slot, value, nil, isConst, annotations)
+	}
+
 	if rawValue == nil && valueType.Id == ArrayType {
 		rawValue = NewArray()
+	} else if isValue && value.Type.Id == ArrayType && valueType.Id == ArrayType {
+		elemType := &valueType.GenericParams[0]
+
+		for _, elem := range value.Value.(*Array).Values() {
+			if !elem.Type.AssignableTo(elemType) {
+				return fmt.Errorf("Cannot store `%s` type %s as type %s", name, elem.Type.FullName(), elemType.FullName())
+			}
+		}
+
+		value = &Value{
+			Type: valueType,
+			Value: value.Value,
+		}
 	}
 
 	if rawValue == nil && !valueType.Optional {
 		return fmt.Errorf("Cannot store `%s` without a value (%s is non-optional)", name, valueType.FullName())
-	} else if isValue && !value.Type.Equals(valueType) {
+	} else if isValue && !value.Type.AssignableTo(valueType) {
 		return fmt.Errorf("Cannot store `%s` type %s as type %s", name, value.Type.FullName(), valueType.FullName())
 	} else if !isValue {
 		value = &Value{
@@ -181,99 +565,351 @@ func (vm *VirtualMachine) processStoreVal(instruction *compiler.StoreVal) error
 		}
 	}
 
-    vm.callStack.Frame().Data[name] = value
+	return vm.bind(slot, value, valueType, isConst, annotations)
+}
+
+func (vm *VirtualMachine) bind(slot int, value *Value, valueType *Type, isConst bool, annotations []Annotation) error {
+	frame := vm.callStack.Frame()
+	name := frame.Names[slot]
+
+	if frame.IsMerged(slot) {
+		return diag.New(diag.ConflictingDefinition, diag.Span{}, "Conflicting definition of `%s` in section `%s`", name, frame.SectionName).
+			WithNote(fmt.Sprintf("use `%s = ...` to override it, or `%s += ...` to extend an array", name, name))
+	}
+
+	annotated, err := vm.annotate(annotations, name, value)
+
+	if err != nil {
+		return err
+	} else if annotated != value && valueType != nil && !annotated.Type.AssignableTo(valueType) {
+		return fmt.Errorf("Cannot store `%s` type %s as type %s", name, value.Type.FullName(), valueType.FullName())
+	}
+
+	binding := *annotated
+	binding.Mutable = !isConst
+
+	// Other bindings can still refer to the same object or array, so only a
+	// copy of it is frozen
+	if isConst && !binding.Frozen() {
+		binding = *binding.Copy()
+		binding.Freeze()
+	}
+
+	frame.Values[slot] = &binding
+
+	if len(annotations) > 0 || frame.ValueAnnotations != nil {
+		frame.SetAnnotations(slot, annotations)
+	}
+
+	if valueType != nil {
+		frame.SetValueType(slot, valueType)
+	}
 
-    // println("@TODO: var validation")
 	return nil
 }
 
-func (vm *VirtualMachine) processLoadConst(instruction *compiler.LoadConst) error {
-	vm.dataStack.Push(instruction.Value)
+func (vm *VirtualMachine) extendArray(array *Value, value *Value) error {
+	if array.IsNull() || array.Type.Id != ArrayType {
+		return fmt.Errorf("Cannot append to non-array %s", array.Type.FullName())
+	} else if array.Frozen() {
+		return vm.frozenError(array)
+	}
+
+	var elemType *Type
+
+	if len(array.Type.GenericParams) > 0 {
+		elemType = &array.Type.GenericParams[0]
+	}
+
+	values := []*Value{value}
+
+	if value.Type.Id == ArrayType && (elemType == nil || elemType.Id != ArrayType) {
+		values = value.Value.(*Array).Values()
+	}
+
+	for _, elem := range values {
+		if elemType == nil {
+			array.Type.GenericParams = []Type{*elem.Type}
+			elemType = &array.Type.GenericParams[0]
+		} else if !elem.Type.AssignableTo(elemType) {
+			return fmt.Errorf("Cannot add %s to %s", elem.Type.FullName(), array.Type.FullName())
+		}
+	}
+
+	for _, elem := range values {
+		array.Value.(*Array).Add(elem)
+	}
+
 	return nil
 }
 
-func (vm *VirtualMachine) processLoadName(instruction *compiler.LoadName) error {
-	vm.dataStack.Push(instruction.Name)
+func (vm *VirtualMachine) processReassign(operands []int32) error {
+	name := vm.name(operands[0])
+	frame, slot := vm.callStack.Frame().Owner(name)
+
+	if frame == nil {
+		return diag.New(diag.NameNotFound, diag.Span{}, "Name `%s` not found", name)
+	}
+
+	return vm.reassign(frame, slot, operands[1] == 1)
+}
+
+func (vm *VirtualMachine) processReassignSlot(operands []int32) error {
+	frame := vm.callStack.Frame().Ancestor(int(operands[0]))
+	slot := int(operands[1])
+
+	if frame.Values[slot] == nil {
+		return diag.New(diag.NameNotFound, diag.Span{}, "Name `%s` not found", frame.Names[slot])
+	}
+
+	return vm.reassign(frame, slot, operands[2] == 1)
+}
+
+func (vm *VirtualMachine) reassign(frame *Frame, slot int, append bool) error {
+	name := frame.Names[slot]
+	value := vm.dataStack.Pop().(*Value)
+	binding := frame.Values[slot]
+
+	if !binding.Mutable {
+		return diag.New(diag.ConstantAssignment, diag.Span{}, "Cannot assign to constant `%s`", name).
+			WithNote("bindings declared with `const` can't be reassigned")
+	} else if append {
+		return vm.extendArray(binding, value)
+	}
+
+	valueType := frame.ValueType(slot)
+
+	if valueType == nil {
+		valueType = binding.Type
+	}
+
+	if !value.Type.AssignableTo(valueType) {
+		return fmt.Errorf("Cannot assign %s to `%s` of type %s", value.Type.FullName(), name, valueType.FullName())
+	}
+
+	reassigned := *value
+	reassigned.Mutable = true
+	frame.Values[slot] = &reassigned
 	return nil
 }
 
-func (vm *VirtualMachine) processLoadMember(instruction *compiler.LoadMember) error {
-	name := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) processSetMember(operands []int32) error {
+	name := vm.name(operands[0])
+	objectValue := vm.dataStack.Pop().(*Value)
 	value := vm.dataStack.Pop().(*Value)
-	_, isCall := vm.peek().(*compiler.MakeCall)
 
-	if isCall {
+	if objectValue.IsNull() {
+		return fmt.Errorf("Cannot set field `%s` of null", name)
+	} else if objectValue.Type.Id != ObjectType {
+		return fmt.Errorf("Cannot use non-object %s as an object", objectValue.Type.FullName())
+	} else if objectValue.Frozen() {
+		return vm.frozenError(objectValue)
+	}
+
+	object := objectValue.Value.(*Object)
+	field := objectValue.Type.ObjectDef.FieldByName(name)
+
+	if field == nil {
+		return fmt.Errorf("%s does not contain the `%s` field", objectValue.Type.FullName(), name)
+	} else if operands[1] == 1 {
+		current, err := vm.field(objectValue, name)
+
+		if err != nil {
+			return err
+		}
+
+		return vm.extendArray(current, value)
+	} else if value.IsNull() && !field.Type.Optional {
+		return fmt.Errorf("Cannot set non-optional field `%s` to null", name)
+	} else if value.IsNull() {
+		delete(object.Fields, name)
+		return nil
+	} else if !value.Type.AssignableTo(field.Type) {
+		return fmt.Errorf("Cannot assign %s to field `%s` of type %s", value.Type.FullName(), name, field.Type.FullName())
+	}
+
+	object.Fields[name] = &Value{
+		Type: field.Type,
+		Mutable: true,
+		Value: value.Value,
+	}
+	return nil
+}
+
+func (vm *VirtualMachine) processLoadConst(operands []int32) error {
+	value := vm.program.Constants[operands[0]]
+
+	vm.dataStack.Push(&Value{
+		Type: vm.constType(value),
+		Value: value,
+	})
+	return nil
+}
+
+func (vm *VirtualMachine) field(value *Value, name string) (*Value, error) {
+	if value.IsNull() {
+		return nil, fmt.Errorf("Cannot read field `%s` of null", name)
+	} else if value.Type.Id != ObjectType {
+		return nil, fmt.Errorf("Cannot use non-object %s as an object", value.Type.FullName())
+	}
+
+	object := value.Value.(*Object)
+
+	if field, exist := object.Fields[name]; exist {
+		return field, nil
+	} else if field := value.Type.ObjectDef.FieldByName(name); field != nil && field.Type.Optional {
+		return NewNull(), nil
+	}
+
+	return nil, fmt.Errorf("%s does not contain the `%s` field", value.Type.FullName(), name)
+}
+
+func (vm *VirtualMachine) processLoadMember(operands []int32) error {
+	name := vm.name(operands[0])
+	value := vm.dataStack.Pop().(*Value)
+	isCall := vm.peek() == compiler.OpMakeCall
+
+	if operands[1] == 1 && value.IsNull() {
+		vm.dataStack.Push(NewNull())
+		return nil
+	} else if isCall {
 		vm.dataStack.Push(&FunctionLookup{
 			Name: name,
 			Value: value,
 		})
 		return nil
-	} else if value.Type.Id == ObjectType {
-		object := value.Value.(*Object)
+	}
 
-		if field, exist := object.Fields[name]; exist {
-			vm.dataStack.Push(field)
-			return nil
-		}
+	field, err := vm.field(value, name)
 
-		return fmt.Errorf("%s does not contain the `%s` field", value.Type.FullName(), name)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("Cannot use non-object %s as an object", value.Type.FullName())
+	vm.dataStack.Push(field)
+	return nil
 }
 
-func (vm *VirtualMachine) processLoadVal(instruction *compiler.LoadVal) error {
-	name := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) processLoadVal(operands []int32) error {
+	name := vm.name(operands[0])
 
 	if value := vm.callStack.Frame().Get(name); value != nil {
 		vm.dataStack.Push(value)
 		return nil
 	}
 
-	return fmt.Errorf("Name `%s` not found", name)
+	return diag.New(diag.NameNotFound, diag.Span{}, "Name `%s` not found", name)
 }
 
-func (vm *VirtualMachine) processSetField(instruction *compiler.SetField) error {
-	value := vm.dataStack.Pop().(interface{})
-	fieldName := vm.dataStack.Pop().(string)
+func (vm *VirtualMachine) processLoadSlot(operands []int32) error {
+	frame := vm.callStack.Frame().Ancestor(int(operands[0]))
+	slot := int(operands[1])
+
+	if value := frame.Values[slot]; value != nil {
+		vm.dataStack.Push(value)
+		return nil
+	}
+
+	// The slot is declared further down, so the name may still refer to an outer binding
+	if frame.Parent != nil {
+		if value := frame.Parent.Get(frame.Names[slot]); value != nil {
+			vm.dataStack.Push(value)
+			return nil
+		}
+	}
+
+	return diag.New(diag.NameNotFound, diag.Span{}, "Name `%s` not found", frame.Names[slot])
+}
+
+func (vm *VirtualMachine) processSetField(operands []int32) error {
+	value := vm.dataStack.Pop().(*Value)
+	fieldName := vm.name(operands[0])
 	object := vm.dataStack.Pop().(*Object)
 	objectType := vm.dataStack.Elem().(*Type)
-    field := objectType.ObjectDef.FieldByName(fieldName)
+	field := objectType.ObjectDef.FieldByName(fieldName)
+
+	if field == nil {
+		return fmt.Errorf("%s does not contain the `%s` field", objectType.FullName(), fieldName)
+	} else if value.IsNull() && !field.Type.Optional {
+		return fmt.Errorf("Cannot set non-optional field `%s` to null", fieldName)
+	} else if value.IsNull() {
+		delete(object.Fields, fieldName)
+		vm.dataStack.Push(object)
+		return nil
+	} else if !value.Type.AssignableTo(field.Type) {
+		return fmt.Errorf("Cannot assign %s to field `%s` of type %s", value.Type.FullName(), fieldName, field.Type.FullName())
+	}
 
-    object.Fields[fieldName] = &Value{
-        Type: field.Type,
-        Mutable: true,
-        Value: value,
-    }
+	object.Fields[fieldName] = &Value{
+		Type: field.Type,
+		Mutable: true,
+		Value: value.Value,
+	}
 
-    vm.dataStack.Push(object)
+	vm.dataStack.Push(object)
+	return nil
+}
+
+func (vm *VirtualMachine) processSpreadObject(operands []int32) error {
+	base, isValue := vm.dataStack.Pop().(*Value)
+	object := vm.dataStack.Pop().(*Object)
+	objectType := vm.dataStack.Pop().(*Type)
+
+	if !isValue || base.Type.Id != ObjectType {
+		return fmt.Errorf("Cannot spread a non-object value")
+	} else if objectType == nil {
+		objectType = base.Type
+	} else if !objectType.AssignableTo(base.Type) {
+		return fmt.Errorf("Cannot spread %s into %s", base.Type.FullName(), objectType.FullName())
+	}
+
+	// Arrays and objects are copied as well, or changing them on the new
+	// object would change the base too
+	for name, field := range base.Value.(*Object).Fields {
+		if field != nil {
+			field = field.Copy()
+		}
 
-	// println("@TODO: field validation")
+		object.Fields[name] = field
+	}
+
+	vm.dataStack.Push(objectType)
+	vm.dataStack.Push(object)
 	return nil
 }
 
-func (vm *VirtualMachine) processNewObject(instruction *compiler.NewObject) error {
+func (vm *VirtualMachine) processNewObject(operands []int32) error {
+	if operands[0] == 0 {
+		vm.dataStack.Push((*Type)(nil))
+	} else if objectType := vm.dataStack.Elem().(*Type); objectType.Id != ObjectType {
+		return fmt.Errorf("Cannot initialize non-object type %s", objectType.FullName())
+	}
+
 	vm.dataStack.Push(NewObject())
 	return nil
 }
 
-func (vm *VirtualMachine) processMakeCall(instruction *compiler.MakeCall) error {
+func (vm *VirtualMachine) processMakeCall(operands []int32) error {
 	elem := vm.dataStack.Pop()
 	lookup, isLookup := elem.(*FunctionLookup)
+	args := make([]*Value, operands[0])
 	var fn *Function
 
+	for i := len(args) - 1; i >= 0; i-- {
+		args[i] = vm.dataStack.Pop().(*Value)
+	}
+
 	if isLookup {
 		var err error
 		fn, err = vm.lookupFunction(lookup)
 
-		vm.dataStack.Push(lookup.Value)
-		instruction.Args++
-
 		if err != nil {
 			return err
 		} else if fn == nil {
 			return fmt.Errorf("Cannot find function `%s` for %s", lookup.Name, lookup.Value.Type.FullName())
 		}
+
+		args = append([]*Value{lookup.Value}, args...)
 	} else {
 		callable := elem.(*Value)
 
@@ -284,94 +920,374 @@ func (vm *VirtualMachine) processMakeCall(instruction *compiler.MakeCall) error
 		fn = callable.Value.(*Function)
 	}
 
-	args := []*Value{}
+	// The frame is left on the call stack when the call fails, so it shows up
+	// in the stack trace
+	frame := NewFrame(FunctionFrame, vm.loc(), nil)
+	frame.FunctionName = fn.Name
+	vm.callStack.Push(frame)
 
-	for i := 0; i < instruction.Args; i++ {
-		args = append(args, vm.dataStack.Pop().(*Value))
+	if fn.ErrorFunc == nil {
+		fn.Func(args)
+	} else if err := fn.ErrorFunc(args); err != nil {
+		return err
 	}
 
-	fn.Func(args)
-
+	vm.callStack.Pop()
+	vm.dataStack.Push(NewNull())
 	return nil
 }
 
-func (vm *VirtualMachine) processMakeObject(instruction *compiler.MakeObject) error {
-	vm.dataStack.Pop() // unused 'object' typename
-
+func (vm *VirtualMachine) processMakeObject(operands []int32) error {
 	fields := []ObjectField{}
 
-    for i := 0; i < instruction.Fields; i++ {
+    for i := int32(0); i < operands[0]; i++ {
         fields = append(fields, *vm.dataStack.Pop().(*ObjectField))
     }
 
-    vm.dataStack.Push(&ObjectDef{Fields: fields})
+	if operands[1] == 0 {
+		vm.dataStack.Push(&ObjectDef{Fields: fields})
+		return nil
+	}
+
+	base := vm.dataStack.Pop().(*Type)
+
+	if base.Id != ObjectType {
+		return fmt.Errorf("Cannot extend non-object type %s", base.FullName())
+	}
+
+	for _, field := range fields {
+		if base.ObjectDef.FieldByName(field.Name) != nil {
+			return fmt.Errorf("Cannot redefine field `%s` of %s", field.Name, base.FullName())
+		}
+	}
+
+	vm.dataStack.Push(&ObjectDef{
+		Base: base,
+		Fields: append(append([]ObjectField{}, base.ObjectDef.Fields...), fields...),
+	})
 	return nil
 }
 
-func (vm *VirtualMachine) processInitialize(instruction *compiler.Initialize) error {
-	// println("@TODO: object validation")
+func (vm *VirtualMachine) processInitialize(operands []int32) error {
+	object := vm.dataStack.Pop().(*Object)
+	value := &Value{
+		Type: vm.dataStack.Pop().(*Type),
+		Value: object,
+	}
+
+	for _, field := range value.Type.ObjectDef.Fields {
+		if len(field.Annotations) == 0 {
+			continue
+		}
+
+		fieldValue, exist := object.Fields[field.Name]
+
+		if !exist {
+			fieldValue = NewNull()
+		}
+
+		result, err := vm.annotate(field.Annotations, field.Name, fieldValue)
+
+		if err != nil {
+			return err
+		} else if result == fieldValue {
+			continue
+		} else if !result.Type.AssignableTo(field.Type) {
+			return fmt.Errorf("Cannot set field `%s` type %s as type %s", field.Name, result.Type.FullName(), field.Type.FullName())
+		} else if !result.IsNull() {
+			object.Fields[field.Name] = &Value{
+				Type: field.Type,
+				Mutable: true,
+				Value: result.Value,
+			}
+		}
+	}
+
+	value, err := vm.annotate(value.Type.Annotations, value.Type.Name, value)
+
+	if err != nil {
+		return err
+	}
+
+	vm.dataStack.Push(value)
 	return nil
 }
 
+func (vm *VirtualMachine) processAssert(operands []int32) error {
+	message, isString := vm.popRaw().(string)
+	cond, isBool := vm.popRaw().(bool)
 
-func (vm *VirtualMachine) Run() error {
-	for vm.hasInstructions() {
-		var err error
-		instr := vm.next()
-
-		switch instruction := instr.(type) {
-		case *compiler.OpenSection:
-			err = vm.processOpenSection(instruction)
-			break
-		case *compiler.CloseSection:
-			err = vm.processCloseSection(instruction)
-			break
-		case *compiler.MakeField:
-			err = vm.processMakeField(instruction)
-			break
-		case *compiler.LoadType:
-			err = vm.processLoadType(instruction)
-			break
-		case *compiler.MakeType:
-			err = vm.processMakeType(instruction)
-			break
-		case *compiler.MakeCall:
-			err = vm.processMakeCall(instruction)
-			break
-		case *compiler.StoreVal:
-			err = vm.processStoreVal(instruction)
-			break
-		case *compiler.LoadConst:
-			err = vm.processLoadConst(instruction)
-			break
-		case *compiler.LoadName:
-			err = vm.processLoadName(instruction)
-			break
-		case *compiler.LoadVal:
-			err = vm.processLoadVal(instruction)
-			break
-		case *compiler.LoadMember:
-			err = vm.processLoadMember(instruction)
-			break
-		case *compiler.SetField:
-			err = vm.processSetField(instruction)
-			break
-		case *compiler.NewObject:
-			err = vm.processNewObject(instruction)
-			break
-		case *compiler.MakeObject:
-			err = vm.processMakeObject(instruction)
-			break
-		case *compiler.Initialize:
-			err = vm.processInitialize(instruction)
-			break
-		default:
-			panic(instruction)
-		}
+	if !isBool {
+		return fmt.Errorf("Cannot use non-boolean value as an assertion condition")
+	} else if !isString {
+		return fmt.Errorf("Cannot use non-string value as an assertion message")
+	}
+
+	if !cond {
+		vm.assertionErrors = append(vm.assertionErrors, diag.New(diag.AssertionFailed, diag.At(vm.loc()), "Assertion failed: %s", message).
+			WithTrace(vm.callStack.Trace(vm.loc())))
+	}
+
+	return nil
+}
+func (vm *VirtualMachine) processCoalesce(operands []int32) error {
+	fallback := vm.dataStack.Pop().(*Value)
+	value := vm.dataStack.Pop().(*Value)
+
+	if value.IsNull() {
+		vm.dataStack.Push(fallback)
+	} else {
+		vm.dataStack.Push(value)
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) processIsNull(operands []int32) error {
+	value := vm.dataStack.Pop().(*Value)
+
+	vm.pushBool(value.IsNull())
+	return nil
+}
+
+func (vm *VirtualMachine) processCompare(operands []int32) error {
+	right := vm.dataStack.Pop().(*Value)
+	left := vm.dataStack.Pop().(*Value)
+
+	switch compiler.Operator(operands[0]) {
+	case compiler.EqualOp:
+		vm.pushBool(left.Equals(right))
+		break
+	case compiler.NotEqualOp:
+		vm.pushBool(!left.Equals(right))
+		break
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) processNot(operands []int32) error {
+	value, err := vm.popBool()
+
+	if err != nil {
+		return err
+	}
+
+	vm.pushBool(!value)
+	return nil
+}
+
+func (vm *VirtualMachine) processJump(operands []int32) error {
+	vm.index = int(operands[0])
+	return nil
+}
+
+func (vm *VirtualMachine) processJumpIfFalse(operands []int32) error {
+	cond, err := vm.popBool()
+
+	if err != nil {
+		return err
+	} else if !cond {
+		vm.index = int(operands[0])
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) processNewArray(operands []int32) error {
+	vm.dataStack.Push(&Value{
+		Type: &Type{Id: ArrayType, Name: "array"},
+		Value: NewArray(),
+	})
+	return nil
+}
+
+func (vm *VirtualMachine) processAppendArray(operands []int32) error {
+	value := vm.dataStack.Pop().(*Value)
+	array := vm.dataStack.Peek(int(operands[0])).(*Value)
+
+	if len(array.Type.GenericParams) == 0 {
+		array.Type.GenericParams = []Type{*value.Type}
+	} else if elemType := &array.Type.GenericParams[0]; !value.Type.AssignableTo(elemType) {
+		return fmt.Errorf("Cannot add %s to %s", value.Type.FullName(), array.Type.FullName())
+	}
+
+	array.Value.(*Array).Add(value)
+	return nil
+}
+
+func (vm *VirtualMachine) processGetIter(operands []int32) error {
+	collection := vm.dataStack.Pop().(*Value)
+
+	if collection.IsNull() ||
+		(collection.Type.Id != ArrayType && collection.Type.Id != ObjectType) {
+		return fmt.Errorf("Cannot iterate over %s", collection.Type.FullName())
+	}
+
+	vm.dataStack.Push(NewIterator(collection))
+	return nil
+}
+
+func (vm *VirtualMachine) processForIter(operands []int32) error {
+	iterator := vm.dataStack.Elem().(*Iterator)
+	key, value, ok := iterator.Next()
+
+	if !ok {
+		vm.dataStack.Pop()
+		vm.index = int(operands[0])
+		return nil
+	}
+
+	if operands[1] == 1 {
+		vm.dataStack.Push(key)
+	}
+
+	vm.dataStack.Push(value)
+	return nil
+}
+
+func (vm *VirtualMachine) matchPattern(value *Value, pattern *compiler.Pattern) (bool, error) {
+	switch pattern.Kind {
+	case compiler.LiteralPattern:
+		return value.Equals(&Value{
+			Type: vm.convertType(pattern.Type),
+			Value: pattern.Value,
+		}), nil
+	case compiler.TypePattern:
+		patternType, err := vm.resolveType(pattern.Type, pattern.TypeName, pattern.Array, pattern.Optional)
 
 		if err != nil {
-			return fmt.Errorf("%s at %d:%d", err.Error(), instr.Loc().Line, instr.Loc().Column)
+			return false, err
+		} else if value.IsNull() {
+			return patternType.Optional && len(pattern.Fields) == 0, nil
+		} else if !value.Type.AssignableTo(patternType) {
+			return false, nil
+		}
+
+		for _, fieldPattern := range pattern.Fields {
+			field, err := vm.field(value, fieldPattern.Name)
+
+			if err != nil {
+				return false, err
+			} else if fieldPattern.Pattern == nil {
+				vm.callStack.Frame().Values[fieldPattern.Slot] = field
+				continue
+			}
+
+			matched, err := vm.matchPattern(field, fieldPattern.Pattern)
+
+			if !matched || err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+
+	return true, nil
+}
+
+func (vm *VirtualMachine) processMatchPattern(operands []int32) error {
+	matched, err := vm.matchPattern(vm.dataStack.Elem().(*Value), vm.program.Constants[operands[0]].(*compiler.Pattern))
+
+	if err != nil {
+		return err
+	}
+
+	vm.pushBool(matched)
+	return nil
+}
+
+func (vm *VirtualMachine) processMatchFail(operands []int32) error {
+	value := vm.dataStack.Pop().(*Value)
+	return diag.New(diag.NoMatchArm, diag.Span{}, "No match arm for %s value", value.Type.FullName())
+}
+
+func (vm *VirtualMachine) processPop(operands []int32) error {
+	vm.dataStack.Pop()
+	return nil
+}
+
+func (vm *VirtualMachine) processPushFrame(operands []int32) error {
+	vm.callStack.Push(NewFrame(BlockFrame, vm.loc(), vm.program.Scopes[operands[0]].Names))
+	return nil
+}
+
+func (vm *VirtualMachine) processPopFrame(operands []int32) error {
+	vm.callStack.Pop()
+	return nil
+}
+
+func (vm *VirtualMachine) frozenError(value *Value) error {
+	return diag.New(diag.FrozenValue, diag.Span{}, "Cannot modify frozen %s", value.Type.FullName()).
+		WithNote("values are frozen when bound with `const` and once the program has finished")
+}
+
+func (vm *VirtualMachine) diagnostic(err error) *diag.Diagnostic {
+	diagnostic, isDiagnostic := err.(*diag.Diagnostic)
+
+	if !isDiagnostic {
+		diagnostic = diag.New(diag.RuntimeError, diag.Span{}, "%s", err.Error())
+	}
+
+	if diagnostic.Span.IsZero() {
+		diagnostic.Span = diag.At(vm.loc())
+	}
+
+	if diagnostic.Trace == nil {
+		diagnostic.Trace = vm.callStack.Trace(vm.loc())
+	}
+
+	return diagnostic
+}
+
+// withAssertions reports err after the assertions that failed before it, so
+// a runtime error doesn't hide them
+func (vm *VirtualMachine) withAssertions(err error) error {
+	if len(vm.assertionErrors) == 0 {
+		return err
+	}
+
+	return append(append(diag.Diagnostics{}, vm.assertionErrors...), diag.From(err)...)
+}
+
+func (vm *VirtualMachine) Run() (err error) {
+	if err := compiler.Verify(vm.program); err != nil {
+		return err
+	}
+
+	// The verifier only tracks the depth of the stack and not what's on it, so
+	// precompiled bytecode can still trip one of the type assertions
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = vm.withAssertions(vm.diagnostic(diag.New(diag.InvalidBytecode, diag.Span{}, "Invalid bytecode: %v", recovered)))
 		}
+	}()
+
+	code := vm.program.Code
+
+	for vm.index < len(code) {
+		vm.offset = vm.index
+		op := compiler.Opcode(code[vm.offset])
+		vm.index += op.Width()
+
+		if err := handlers[op](vm, code[vm.offset + 1:vm.index]); err != nil {
+			return vm.withAssertions(vm.diagnostic(err))
+		}
+	}
+
+	if err := vm.checkSections(vm.root); err != nil {
+		return vm.withAssertions(err)
+	}
+
+	for _, value := range vm.root.Values {
+		if value != nil {
+			value.Freeze()
+		}
+	}
+
+	if len(vm.assertionErrors) > 0 {
+		return vm.assertionErrors
 	}
 
 	return nil